@@ -0,0 +1,188 @@
+package mapreduce
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+
+	"golang.org/x/net/context"
+)
+
+// BusyError is returned by an Executor that is overloaded (see WithWorkers).
+// A MapReduce tries another replica of the file when a node is busy.
+type BusyError struct {
+	// Running and Queued are the number of executions when the execution
+	// was rejected.
+	Running, Queued int
+}
+
+// Error implements error.
+func (e *BusyError) Error() string {
+	return fmt.Sprintf("executor is busy (%d running, %d queued)", e.Running, e.Queued)
+}
+
+// isBusy reports if the error is a *BusyError.
+func isBusy(err error) bool {
+	var busy *BusyError
+	return errors.As(err, &busy)
+}
+
+// PriorityFunc returns the priority of an execution. Executions with a
+// higher priority leave the queue first.
+type PriorityFunc func(spec JobSpec, algName string) int
+
+// AlgPriority returns a PriorityFunc that looks up the priority of the
+// algorithm. Other algorithms have a priority of 0.
+func AlgPriority(priorities map[string]int) PriorityFunc {
+	return func(spec JobSpec, algName string) int {
+		return priorities[algName]
+	}
+}
+
+// TenantPriority returns a PriorityFunc that looks up the priority of the
+// tenant of the JobSpec (see WithPriority). Other tenants have a priority of
+// 0.
+func TenantPriority(priorities map[string]int) PriorityFunc {
+	return func(spec JobSpec, algName string) int {
+		return priorities[spec.Tenant]
+	}
+}
+
+// WithWorkers limits the number of concurrent executions to the given number
+// of workers. Up to queueSize executions wait for a worker. Further
+// executions are rejected with a *BusyError. The wait is bound to the
+// context of the execution. A workers value of 0 (the default) does not
+// limit the executions.
+func WithWorkers(workers, queueSize int) ExecutorOption {
+	return func(e *Executor) {
+		if workers <= 0 {
+			e.admission = nil
+			return
+		}
+
+		e.admission = &admission{
+			workers:   workers,
+			queueSize: queueSize,
+		}
+	}
+}
+
+// WithPriority sets the priority of queued executions (see WithWorkers). When
+// the queue is full, an execution with a higher priority displaces the
+// queued execution with the lowest priority, which is rejected with a
+// *BusyError. By default every execution has the same priority.
+func WithPriority(f PriorityFunc) ExecutorOption {
+	return func(e *Executor) {
+		e.priority = f
+	}
+}
+
+// Queued returns the number of executions that wait for a worker (see
+// WithWorkers).
+func (e *Executor) Queued() int {
+	if e.admission == nil {
+		return 0
+	}
+
+	e.admission.mu.Lock()
+	defer e.admission.mu.Unlock()
+	return len(e.admission.queue)
+}
+
+type admission struct {
+	workers   int
+	queueSize int
+
+	mu      sync.Mutex
+	seq     int
+	running int
+
+	// queue is sorted by priority and then arrival.
+	queue []*ticket
+}
+
+type ticket struct {
+	priority int
+	seq      int
+
+	// ch receives nil when a worker is free or a *BusyError when the
+	// ticket is displaced.
+	ch chan error
+}
+
+// acquire waits for a free worker.
+func (a *admission) acquire(priority int, ctx context.Context) error {
+	a.mu.Lock()
+	if a.running < a.workers {
+		a.running++
+		a.mu.Unlock()
+		return nil
+	}
+
+	if len(a.queue) >= a.queueSize {
+		if len(a.queue) == 0 || a.queue[len(a.queue)-1].priority >= priority {
+			err := a.busy()
+			a.mu.Unlock()
+			return err
+		}
+
+		lowest := a.queue[len(a.queue)-1]
+		a.queue = a.queue[:len(a.queue)-1]
+		lowest.ch <- a.busy()
+	}
+
+	a.seq++
+	t := &ticket{priority: priority, seq: a.seq, ch: make(chan error, 1)}
+	i := sort.Search(len(a.queue), func(i int) bool {
+		return a.queue[i].priority < priority
+	})
+	a.queue = append(a.queue, nil)
+	copy(a.queue[i+1:], a.queue[i:])
+	a.queue[i] = t
+	a.mu.Unlock()
+
+	select {
+	case err := <-t.ch:
+		return err
+	case <-ctx.Done():
+		a.mu.Lock()
+		defer a.mu.Unlock()
+
+		for i, q := range a.queue {
+			if q == t {
+				a.queue = append(a.queue[:i], a.queue[i+1:]...)
+				return ctx.Err()
+			}
+		}
+
+		// The ticket left the queue in the meantime.
+		if err := <-t.ch; err == nil {
+			a.releaseLocked()
+		}
+
+		return ctx.Err()
+	}
+}
+
+// release hands the worker to the next queued execution or frees it.
+func (a *admission) release() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.releaseLocked()
+}
+
+func (a *admission) releaseLocked() {
+	if len(a.queue) == 0 {
+		a.running--
+		return
+	}
+
+	t := a.queue[0]
+	a.queue = a.queue[1:]
+	t.ch <- nil
+}
+
+func (a *admission) busy() *BusyError {
+	return &BusyError{Running: a.running, Queued: len(a.queue)}
+}
@@ -0,0 +1,293 @@
+package mapreduce
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/poy/mapreduce/internal/lru"
+)
+
+// ParamType is the type of an algorithm parameter.
+type ParamType int
+
+const (
+	// StringParam is a parameter that is used as is.
+	StringParam ParamType = iota
+
+	// IntParam is a parameter that is parsed as an int64.
+	IntParam
+
+	// FloatParam is a parameter that is parsed as a float64.
+	FloatParam
+
+	// BoolParam is a parameter that is parsed as a bool.
+	BoolParam
+)
+
+// String implements fmt.Stringer.
+func (t ParamType) String() string {
+	switch t {
+	case StringParam:
+		return "string"
+	case IntParam:
+		return "int"
+	case FloatParam:
+		return "float"
+	case BoolParam:
+		return "bool"
+	default:
+		return fmt.Sprintf("ParamType(%d)", int(t))
+	}
+}
+
+// ParamSpec declares a parameter of an algorithm.
+type ParamSpec struct {
+	Name string
+	Type ParamType
+
+	// Required parameters have to be set by the JobSpec.
+	Required bool
+
+	// Default is used when an optional parameter is not set. An empty
+	// Default leaves the parameter unset.
+	Default string
+}
+
+// Params holds the decoded parameters for an algorithm. The values are of
+// type string, int64, float64 or bool depending on the ParamType.
+type Params map[string]interface{}
+
+// String returns the given string parameter.
+func (p Params) String(name string) string {
+	v, _ := p[name].(string)
+	return v
+}
+
+// Int returns the given int parameter.
+func (p Params) Int(name string) int64 {
+	v, _ := p[name].(int64)
+	return v
+}
+
+// Float returns the given float parameter.
+func (p Params) Float(name string) float64 {
+	v, _ := p[name].(float64)
+	return v
+}
+
+// Bool returns the given bool parameter.
+func (p Params) Bool(name string) bool {
+	v, _ := p[name].(bool)
+	return v
+}
+
+// AlgorithmFactory builds an Algorithm for the given parameters.
+type AlgorithmFactory func(params Params) (alg Algorithm, err error)
+
+// ParamError is returned when the parameters of a job do not match the
+// declared schema of the algorithm.
+type ParamError struct {
+	AlgName string
+	Param   string
+	Reason  string
+}
+
+// Error implements error.
+func (e *ParamError) Error() string {
+	return fmt.Sprintf("algorithm %s: parameter %s: %s", e.AlgName, e.Param, e.Reason)
+}
+
+// AlgRegistry implements AlgorithmFetcher. It builds algorithms from
+// factories with the parameters of the JobSpec that is encoded in the meta
+// information. Ad-hoc meta information is treated as having no parameters.
+//
+// Built algorithms are cached per name and parameter set (see
+// WithMaxCachedAlgs).
+//
+// An AlgRegistry has to be created with NewAlgRegistry().
+type AlgRegistry struct {
+	maxCachedAlgs int
+
+	mu    sync.RWMutex
+	algs  map[string]registeredAlg
+	cache *lru.Cache
+}
+
+// AlgRegistryOption is used to configure a new AlgRegistry.
+type AlgRegistryOption func(*AlgRegistry)
+
+// WithMaxCachedAlgs sets the number of built algorithms that are cached. The
+// least recently used algorithm is dropped. A value of 0 disables the cache.
+// It defaults to 128.
+func WithMaxCachedAlgs(n int) AlgRegistryOption {
+	return func(r *AlgRegistry) {
+		r.maxCachedAlgs = n
+	}
+}
+
+type registeredAlg struct {
+	schema  map[string]ParamSpec
+	factory AlgorithmFactory
+}
+
+// NewAlgRegistry returns a new AlgRegistry.
+func NewAlgRegistry(opts ...AlgRegistryOption) *AlgRegistry {
+	r := &AlgRegistry{
+		maxCachedAlgs: 128,
+		algs:          make(map[string]registeredAlg),
+	}
+
+	for _, o := range opts {
+		o(r)
+	}
+	r.cache = lru.New(r.maxCachedAlgs)
+
+	return r
+}
+
+// Register adds the factory for the given algorithm name. The schema
+// declares every parameter the algorithm accepts. It returns an error if
+// the name is already registered or the schema is invalid.
+func (r *AlgRegistry) Register(name string, schema []ParamSpec, factory AlgorithmFactory) error {
+	s := make(map[string]ParamSpec)
+	for _, p := range schema {
+		if _, ok := s[p.Name]; ok {
+			return &ParamError{AlgName: name, Param: p.Name, Reason: "declared twice"}
+		}
+
+		if p.Default != "" {
+			if _, err := parseParam(p.Type, p.Default); err != nil {
+				return &ParamError{AlgName: name, Param: p.Name, Reason: fmt.Sprintf("invalid default: %s", err)}
+			}
+		}
+
+		s[p.Name] = p
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.algs[name]; ok {
+		return fmt.Errorf("algorithm %s is already registered", name)
+	}
+
+	r.algs[name] = registeredAlg{
+		schema:  s,
+		factory: factory,
+	}
+
+	return nil
+}
+
+// Alg implements AlgorithmFetcher.
+func (r *AlgRegistry) Alg(name string, meta []byte) (Algorithm, error) {
+	r.mu.RLock()
+	ra, ok := r.algs[name]
+	r.mu.RUnlock()
+	if !ok {
+		return Algorithm{}, fmt.Errorf("unknown algorithm: %s", name)
+	}
+
+	spec, err := UnmarshalJobSpec(meta)
+	if err != nil && err != ErrNotJobSpec {
+		return Algorithm{}, err
+	}
+
+	raw, err := ra.resolve(name, spec.Params)
+	if err != nil {
+		return Algorithm{}, err
+	}
+
+	key := cacheKey(name, raw)
+	r.mu.Lock()
+	cached, ok := r.cache.Get(key)
+	r.mu.Unlock()
+	if ok {
+		return cached.(Algorithm), nil
+	}
+
+	params := make(Params)
+	for k, v := range raw {
+		// Each value was validated by resolve.
+		params[k], _ = parseParam(ra.schema[k].Type, v)
+	}
+
+	alg, err := ra.factory(params)
+	if err != nil {
+		return Algorithm{}, err
+	}
+
+	r.mu.Lock()
+	r.cache.Add(key, alg)
+	r.mu.Unlock()
+
+	return alg, nil
+}
+
+// resolve validates the given parameters against the schema and applies
+// the defaults.
+func (ra registeredAlg) resolve(name string, params map[string]string) (map[string]string, error) {
+	raw := make(map[string]string)
+	for k, v := range params {
+		p, ok := ra.schema[k]
+		if !ok {
+			return nil, &ParamError{AlgName: name, Param: k, Reason: "unknown parameter"}
+		}
+
+		if _, err := parseParam(p.Type, v); err != nil {
+			return nil, &ParamError{AlgName: name, Param: k, Reason: fmt.Sprintf("expected %s: %s", p.Type, err)}
+		}
+
+		raw[k] = v
+	}
+
+	for k, p := range ra.schema {
+		if _, ok := raw[k]; ok {
+			continue
+		}
+
+		if p.Required {
+			return nil, &ParamError{AlgName: name, Param: k, Reason: "missing required parameter"}
+		}
+
+		if p.Default != "" {
+			raw[k] = p.Default
+		}
+	}
+
+	return raw, nil
+}
+
+func parseParam(t ParamType, v string) (interface{}, error) {
+	switch t {
+	case StringParam:
+		return v, nil
+	case IntParam:
+		return strconv.ParseInt(v, 10, 64)
+	case FloatParam:
+		return strconv.ParseFloat(v, 64)
+	case BoolParam:
+		return strconv.ParseBool(v)
+	default:
+		return nil, fmt.Errorf("unknown type %s", t)
+	}
+}
+
+// cacheKey returns a key that is unique for the name and parameter set.
+func cacheKey(name string, params map[string]string) string {
+	keys := make([]string, 0, len(params))
+	for k := range params {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	parts := []string{strconv.Quote(name)}
+	for _, k := range keys {
+		parts = append(parts, strconv.Quote(k)+"="+strconv.Quote(params[k]))
+	}
+
+	return strings.Join(parts, ",")
+}
@@ -0,0 +1,125 @@
+package mapreduce_test
+
+import (
+	"testing"
+
+	"github.com/poy/mapreduce"
+	"github.com/poy/onpar"
+	. "github.com/poy/onpar/expect"
+	. "github.com/poy/onpar/matchers"
+)
+
+type TAR struct {
+	*testing.T
+	r      *mapreduce.AlgRegistry
+	params chan mapreduce.Params
+}
+
+func TestAlgRegistry(t *testing.T) {
+	t.Parallel()
+	o := onpar.New()
+	defer o.Run(t)
+
+	o.BeforeEach(func(t *testing.T) TAR {
+		r := mapreduce.NewAlgRegistry()
+		params := make(chan mapreduce.Params, 100)
+		err := r.Register("threshold", []mapreduce.ParamSpec{
+			{Name: "min", Type: mapreduce.IntParam, Required: true},
+			{Name: "scale", Type: mapreduce.FloatParam, Default: "1.5"},
+			{Name: "label", Type: mapreduce.StringParam},
+		}, func(p mapreduce.Params) (mapreduce.Algorithm, error) {
+			params <- p
+			return mapreduce.Algorithm{}, nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return TAR{
+			T:      t,
+			r:      r,
+			params: params,
+		}
+	})
+
+	o.Spec("it decodes the parameters from the JobSpec", func(t TAR) {
+		meta := mapreduce.JobSpec{Params: map[string]string{"min": "7", "label": "x"}}.Marshal()
+		_, err := t.r.Alg("threshold", meta)
+		Expect(t, err == nil).To(BeTrue())
+
+		var p mapreduce.Params
+		Expect(t, t.params).To(Chain(Receive(), Fetch(&p)))
+		Expect(t, p.Int("min")).To(Equal(int64(7)))
+		Expect(t, p.Float("scale")).To(Equal(1.5))
+		Expect(t, p.String("label")).To(Equal("x"))
+	})
+
+	o.Spec("it caches the algorithm per parameter set", func(t TAR) {
+		t.r.Alg("threshold", mapreduce.JobSpec{Params: map[string]string{"min": "7"}}.Marshal())
+		t.r.Alg("threshold", mapreduce.JobSpec{Params: map[string]string{"min": "7", "scale": "1.5"}}.Marshal())
+		t.r.Alg("threshold", mapreduce.JobSpec{Params: map[string]string{"min": "8"}}.Marshal())
+
+		Expect(t, t.params).To(HaveLen(2))
+	})
+
+	o.Spec("it evicts the least recently used algorithm", func(t TAR) {
+		r := mapreduce.NewAlgRegistry(mapreduce.WithMaxCachedAlgs(1))
+		built := make(chan struct{}, 10)
+		r.Register("some-alg", []mapreduce.ParamSpec{{Name: "n", Type: mapreduce.IntParam}}, func(p mapreduce.Params) (mapreduce.Algorithm, error) {
+			built <- struct{}{}
+			return mapreduce.Algorithm{}, nil
+		})
+
+		for _, n := range []string{"1", "2", "1"} {
+			r.Alg("some-alg", mapreduce.JobSpec{Params: map[string]string{"n": n}}.Marshal())
+		}
+		Expect(t, built).To(HaveLen(3))
+	})
+
+	o.Spec("it returns an error for an unknown algorithm", func(t TAR) {
+		_, err := t.r.Alg("unknown", nil)
+		Expect(t, err == nil).To(BeFalse())
+	})
+
+	o.Spec("it returns a ParamError for an unknown parameter", func(t TAR) {
+		meta := mapreduce.JobSpec{Params: map[string]string{"min": "7", "max": "9"}}.Marshal()
+		_, err := t.r.Alg("threshold", meta)
+
+		perr, ok := err.(*mapreduce.ParamError)
+		Expect(t, ok).To(BeTrue())
+		Expect(t, perr.Param).To(Equal("max"))
+	})
+
+	o.Spec("it returns a ParamError for an invalid parameter", func(t TAR) {
+		meta := mapreduce.JobSpec{Params: map[string]string{"min": "seven"}}.Marshal()
+		_, err := t.r.Alg("threshold", meta)
+
+		perr, ok := err.(*mapreduce.ParamError)
+		Expect(t, ok).To(BeTrue())
+		Expect(t, perr.Param).To(Equal("min"))
+	})
+
+	o.Spec("it returns a ParamError for a missing required parameter", func(t TAR) {
+		_, err := t.r.Alg("threshold", []byte("ad-hoc-meta"))
+
+		perr, ok := err.(*mapreduce.ParamError)
+		Expect(t, ok).To(BeTrue())
+		Expect(t, perr.Param).To(Equal("min"))
+	})
+
+	o.Spec("it does not register a name twice", func(t TAR) {
+		err := t.r.Register("threshold", nil, func(mapreduce.Params) (mapreduce.Algorithm, error) {
+			return mapreduce.Algorithm{}, nil
+		})
+		Expect(t, err == nil).To(BeFalse())
+	})
+
+	o.Spec("it rejects an invalid default", func(t TAR) {
+		err := t.r.Register("other", []mapreduce.ParamSpec{
+			{Name: "min", Type: mapreduce.IntParam, Default: "x"},
+		}, func(mapreduce.Params) (mapreduce.Algorithm, error) {
+			return mapreduce.Algorithm{}, nil
+		})
+		Expect(t, err == nil).To(BeFalse())
+	})
+}
@@ -20,3 +20,30 @@ func (f AlgFetcherMap) Alg(name string, meta []byte) (Algorithm, error) {
 
 	return alg, nil
 }
+
+// VersionMismatchError is returned when the version of an algorithm does
+// not match the expected version.
+type VersionMismatchError struct {
+	AlgName  string
+	Expected string
+	Actual   string
+}
+
+// Error implements error.
+func (e *VersionMismatchError) Error() string {
+	return fmt.Sprintf("algorithm %s: expected version %q, have %q", e.AlgName, e.Expected, e.Actual)
+}
+
+// checkVersion returns a *VersionMismatchError if the algorithm does not
+// have the expected version. An empty expected version matches any version.
+func checkVersion(algName, expected string, alg Algorithm) error {
+	if expected == "" || expected == alg.Version {
+		return nil
+	}
+
+	return &VersionMismatchError{
+		AlgName:  algName,
+		Expected: expected,
+		Actual:   alg.Version,
+	}
+}
@@ -0,0 +1,200 @@
+package mapreduce
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// BreakerState is the state of the circuit breaker of a node.
+type BreakerState int
+
+const (
+	// BreakerClosed nodes are used.
+	BreakerClosed BreakerState = iota
+
+	// BreakerOpen nodes are avoided.
+	BreakerOpen
+
+	// BreakerHalfOpen nodes are probed by a single Network.Execute.
+	BreakerHalfOpen
+)
+
+// String implements fmt.Stringer.
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerClosed:
+		return "closed"
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	default:
+		return fmt.Sprintf("BreakerState(%d)", int(s))
+	}
+}
+
+// WithCircuitBreaker enables a circuit breaker per node. A node that fails
+// the given number of consecutive Network.Executes is opened and avoided for
+// the cooldown, as long as another replica is available. After the cooldown,
+// a single Network.Execute probes the node (half-open). Its outcome closes or
+// opens the breaker again. The breakers are shared by every Calculate.
+func WithCircuitBreaker(failures int, cooldown time.Duration) MapReduceOption {
+	return func(r *MapReduce) {
+		r.breakers = &breakers{
+			failures: failures,
+			cooldown: cooldown,
+			nodes:    make(map[string]*breaker),
+		}
+	}
+}
+
+// BreakerState returns the state of the circuit breaker for the node. It is
+// always BreakerClosed if WithCircuitBreaker is not used.
+func (r MapReduce) BreakerState(nodeID string) BreakerState {
+	if r.breakers == nil {
+		return BreakerClosed
+	}
+
+	return r.breakers.state(nodeID)
+}
+
+// pickNode returns a random node, that is not excluded, from the given
+// replicas. It avoids nodes with an open circuit breaker. If no other node is
+// available, it falls back to the excluded node or a node with an open
+// circuit breaker.
+func (r MapReduce) pickNode(ids []string, exclude string) string {
+	var candidates []string
+	for _, id := range ids {
+		if id != exclude {
+			candidates = append(candidates, id)
+		}
+	}
+
+	if len(candidates) == 0 {
+		return exclude
+	}
+
+	if r.breakers == nil {
+		return candidates[rand.Intn(len(candidates))]
+	}
+
+	for _, i := range rand.Perm(len(candidates)) {
+		if r.breakers.allow(candidates[i]) {
+			return candidates[i]
+		}
+	}
+
+	return candidates[rand.Intn(len(candidates))]
+}
+
+// recordOutcome feeds the outcome of a Network.Execute to the circuit
+// breaker of the node. Canceled Network.Executes and busy nodes are ignored,
+// but they release the probe of a half-open node.
+func (r MapReduce) recordOutcome(id string, err error) {
+	if r.breakers == nil {
+		return
+	}
+
+	if errors.Is(err, context.Canceled) || isBusy(err) {
+		r.breakers.release(id)
+		return
+	}
+
+	r.breakers.record(id, err == nil)
+}
+
+type breakers struct {
+	failures int
+	cooldown time.Duration
+
+	mu    sync.Mutex
+	nodes map[string]*breaker
+}
+
+type breaker struct {
+	state    BreakerState
+	failures int
+	openedAt time.Time
+	probing  bool
+}
+
+func (b *breakers) node(id string) *breaker {
+	n, ok := b.nodes[id]
+	if !ok {
+		n = &breaker{}
+		b.nodes[id] = n
+	}
+
+	return n
+}
+
+func (b *breakers) state(id string) BreakerState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	n := b.node(id)
+	if n.state == BreakerOpen && time.Since(n.openedAt) >= b.cooldown {
+		return BreakerHalfOpen
+	}
+
+	return n.state
+}
+
+// allow reports if the node can be used. A half-open node allows a single
+// probe.
+func (b *breakers) allow(id string) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	n := b.node(id)
+	switch n.state {
+	case BreakerOpen:
+		if time.Since(n.openedAt) < b.cooldown {
+			return false
+		}
+		n.state = BreakerHalfOpen
+		n.probing = true
+		return true
+	case BreakerHalfOpen:
+		if n.probing {
+			return false
+		}
+		n.probing = true
+		return true
+	default:
+		return true
+	}
+}
+
+func (b *breakers) record(id string, success bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	n := b.node(id)
+	if success {
+		n.state = BreakerClosed
+		n.failures = 0
+		n.probing = false
+		return
+	}
+
+	n.failures++
+	if n.state == BreakerHalfOpen || n.failures >= b.failures {
+		n.state = BreakerOpen
+		n.openedAt = time.Now()
+		n.probing = false
+	}
+}
+
+// release allows another probe of a half-open node.
+func (b *breakers) release(id string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.node(id).probing = false
+}
@@ -0,0 +1,170 @@
+package mapreduce
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"sync"
+
+	"github.com/poy/mapreduce/internal/lru"
+	"golang.org/x/net/context"
+)
+
+// VersionedFileSystem is an optional extension of a FileSystem. It is
+// required to cache results (see WithExecutorCache and WithCache).
+type VersionedFileSystem interface {
+	FileSystem
+
+	// FileVersion returns the version (e.g., an etag or modification time)
+	// of the file. It has to change whenever the content of the file
+	// changes.
+	FileVersion(file string, ctx context.Context, meta []byte) (version string, err error)
+}
+
+// CacheKey identifies the result of an algorithm for a version of a file.
+type CacheKey struct {
+	File        string
+	FileVersion string
+	AlgName     string
+	AlgVersion  string
+
+	// MetaHash is the hash of the meta information and the resolved
+	// JobSpec (see ResolveJobSpec). Fields of the JobSpec that do not
+	// affect the result (e.g., ID and Deadline) are ignored.
+	MetaHash string
+}
+
+// String returns the canonical encoding of the CacheKey.
+func (k CacheKey) String() string {
+	return strings.Join([]string{k.File, k.FileVersion, k.AlgName, k.AlgVersion, k.MetaHash}, "\x00")
+}
+
+// ResultCache stores the results of files. It has to be safe for concurrent
+// use. The cache subpackage provides an in-memory LRU and an on-disk
+// implementation.
+type ResultCache interface {
+	// Get returns the cached result.
+	Get(key CacheKey) (result map[string][]byte, ok bool)
+
+	// Put stores the result.
+	Put(key CacheKey, result map[string][]byte)
+
+	// Invalidate drops every result of the file.
+	Invalidate(file string)
+}
+
+// The names of the cache metrics.
+const (
+	// MetricCacheHits counts the files whose result was cached.
+	MetricCacheHits = "mapreduce_cache_hits_total"
+
+	// MetricCacheMisses counts the files whose result was not cached.
+	MetricCacheMisses = "mapreduce_cache_misses_total"
+)
+
+// WithExecutorCache caches the result of each file. Results are only cached
+// if the FileSystem implements VersionedFileSystem. When the FileSystem
+// reports a new version of a file, the results of the file are invalidated.
+// Errors are not cached and a Report of a cached result does not count any
+// records.
+func WithExecutorCache(c ResultCache) ExecutorOption {
+	return func(e *Executor) {
+		e.cache = newResultCache(c)
+	}
+}
+
+// WithCache caches the result that a node returns for each file. Results are
+// only cached if the FileSystem implements VersionedFileSystem. Cached files
+// are not executed on any node.
+func WithCache(c ResultCache) MapReduceOption {
+	return func(r *MapReduce) {
+		r.cache = newResultCache(c)
+	}
+}
+
+// maxFileVersions is the number of files whose last version is tracked to
+// invalidate their results. The results of other files are still keyed by
+// their version, but they are only dropped by the ResultCache itself.
+const maxFileVersions = 10000
+
+// resultCache wraps a ResultCache to invalidate files with a new version.
+type resultCache struct {
+	c ResultCache
+
+	mu       sync.Mutex
+	versions *lru.Cache
+}
+
+func newResultCache(c ResultCache) *resultCache {
+	return &resultCache{
+		c:        c,
+		versions: lru.New(maxFileVersions),
+	}
+}
+
+// key returns the CacheKey of the file. It reports false if the FileSystem
+// does not report the version of the file.
+func (c *resultCache) key(fs FileSystem, file, algName, algVersion string, ctx context.Context, meta []byte) (CacheKey, bool) {
+	if c == nil {
+		return CacheKey{}, false
+	}
+
+	vfs, ok := fs.(VersionedFileSystem)
+	if !ok {
+		return CacheKey{}, false
+	}
+
+	version, err := vfs.FileVersion(file, ctx, meta)
+	if err != nil || version == "" {
+		return CacheKey{}, false
+	}
+
+	c.mu.Lock()
+	last, seen := c.versions.Get(file)
+	c.versions.Add(file, version)
+	c.mu.Unlock()
+
+	if seen && last != version {
+		c.c.Invalidate(file)
+	}
+
+	return CacheKey{
+		File:        file,
+		FileVersion: version,
+		AlgName:     algName,
+		AlgVersion:  algVersion,
+		MetaHash:    metaHash(ctx, meta),
+	}, true
+}
+
+func (c *resultCache) get(key CacheKey) (map[string][]byte, bool) {
+	return c.c.Get(key)
+}
+
+func (c *resultCache) put(key CacheKey, result map[string][]byte) {
+	c.c.Put(key, result)
+}
+
+// metaHash returns the hash of the meta information and the resolved
+// JobSpec (see ResolveJobSpec). Only the fields of the JobSpec that affect
+// the result are hashed.
+func metaHash(ctx context.Context, meta []byte) string {
+	h := sha256.New()
+	if spec, ok := ResolveJobSpec(ctx, meta); ok {
+		h.Write(JobSpec{
+			Params: spec.Params,
+			Script: spec.Script,
+			Sample: spec.Sample,
+			Meta:   spec.Meta,
+		}.Marshal())
+	}
+
+	// Ad-hoc meta information might affect the result as well.
+	if !bytes.HasPrefix(meta, jobSpecPrefix) {
+		h.Write([]byte{0})
+		h.Write(meta)
+	}
+
+	return hex.EncodeToString(h.Sum(nil))
+}
@@ -0,0 +1,48 @@
+package cache
+
+import (
+	"sync"
+
+	"github.com/poy/mapreduce"
+)
+
+// Checkpoints implements mapreduce.CheckpointStore. It keeps the checkpoints
+// in memory.
+//
+// Checkpoints have to be created with NewCheckpoints().
+type Checkpoints struct {
+	mu          sync.Mutex
+	checkpoints map[mapreduce.CheckpointKey]mapreduce.Checkpoint
+}
+
+// NewCheckpoints returns new Checkpoints.
+func NewCheckpoints() *Checkpoints {
+	return &Checkpoints{
+		checkpoints: make(map[mapreduce.CheckpointKey]mapreduce.Checkpoint),
+	}
+}
+
+// Load implements mapreduce.CheckpointStore.
+func (c *Checkpoints) Load(key mapreduce.CheckpointKey) (mapreduce.Checkpoint, bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	cp, ok := c.checkpoints[key]
+	if !ok {
+		return mapreduce.Checkpoint{}, false, nil
+	}
+	cp.State = clone(cp.State)
+
+	return cp, true, nil
+}
+
+// Save implements mapreduce.CheckpointStore.
+func (c *Checkpoints) Save(key mapreduce.CheckpointKey, cp mapreduce.Checkpoint) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	cp.State = clone(cp.State)
+	c.checkpoints[key] = cp
+
+	return nil
+}
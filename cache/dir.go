@@ -0,0 +1,136 @@
+package cache
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/gob"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+
+	"github.com/poy/mapreduce"
+)
+
+// Dir implements mapreduce.ResultCache and mapreduce.CheckpointStore. It
+// stores each result and checkpoint as a file in a directory so they survive
+// restarts. Errors of the file system are treated as cache misses.
+type Dir struct {
+	path string
+}
+
+// NewDir returns a new Dir that stores the results in the directory. The
+// directory is created if it does not exist.
+func NewDir(path string) (*Dir, error) {
+	if err := os.MkdirAll(path, 0o755); err != nil {
+		return nil, err
+	}
+
+	return &Dir{path: path}, nil
+}
+
+// Get implements mapreduce.ResultCache.
+func (d *Dir) Get(key mapreduce.CacheKey) (map[string][]byte, bool) {
+	data, err := os.ReadFile(d.name(key))
+	if err != nil {
+		return nil, false
+	}
+
+	var result map[string][]byte
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&result); err != nil {
+		return nil, false
+	}
+
+	return result, true
+}
+
+// Put implements mapreduce.ResultCache.
+func (d *Dir) Put(key mapreduce.CacheKey, result map[string][]byte) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(result); err != nil {
+		return
+	}
+
+	dir := d.dir(key.File)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return
+	}
+
+	writeFile(d.name(key), buf.Bytes())
+}
+
+// Invalidate implements mapreduce.ResultCache.
+func (d *Dir) Invalidate(file string) {
+	os.RemoveAll(d.dir(file))
+}
+
+// Load implements mapreduce.CheckpointStore.
+func (d *Dir) Load(key mapreduce.CheckpointKey) (mapreduce.Checkpoint, bool, error) {
+	data, err := os.ReadFile(d.checkpoint(key))
+	if os.IsNotExist(err) {
+		return mapreduce.Checkpoint{}, false, nil
+	}
+	if err != nil {
+		return mapreduce.Checkpoint{}, false, err
+	}
+
+	var c mapreduce.Checkpoint
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&c); err != nil {
+		return mapreduce.Checkpoint{}, false, err
+	}
+
+	return c, true, nil
+}
+
+// Save implements mapreduce.CheckpointStore.
+func (d *Dir) Save(key mapreduce.CheckpointKey, c mapreduce.Checkpoint) error {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(c); err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Join(d.path, "checkpoints"), 0o755); err != nil {
+		return err
+	}
+
+	return writeFile(d.checkpoint(key), buf.Bytes())
+}
+
+// dir returns the directory that holds the results of the file.
+func (d *Dir) dir(file string) string {
+	return filepath.Join(d.path, hash(file))
+}
+
+func (d *Dir) name(key mapreduce.CacheKey) string {
+	return filepath.Join(d.dir(key.File), hash(key.String()))
+}
+
+// checkpoint returns the name of the checkpoint. Checkpoints are kept apart
+// from the results so Invalidate does not drop them.
+func (d *Dir) checkpoint(key mapreduce.CheckpointKey) string {
+	return filepath.Join(d.path, "checkpoints", hash(key.File+"\x00"+key.AlgName+"\x00"+key.AlgVersion+"\x00"+key.MetaHash))
+}
+
+// writeFile writes the data to a temporary file and renames it so concurrent
+// reads never see partial data.
+func writeFile(name string, data []byte) error {
+	f, err := os.CreateTemp(filepath.Dir(name), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	_, err = f.Write(data)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(f.Name(), name)
+}
+
+func hash(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,82 @@
+package cache_test
+
+import (
+	"testing"
+
+	"github.com/poy/mapreduce"
+	"github.com/poy/mapreduce/cache"
+	"github.com/poy/onpar"
+	. "github.com/poy/onpar/expect"
+	. "github.com/poy/onpar/matchers"
+)
+
+type TD struct {
+	*testing.T
+	path string
+	d    *cache.Dir
+}
+
+func TestDir(t *testing.T) {
+	t.Parallel()
+	o := onpar.New()
+	defer o.Run(t)
+
+	o.BeforeEach(func(t *testing.T) TD {
+		path := t.TempDir()
+		d, err := cache.NewDir(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return TD{
+			T:    t,
+			path: path,
+			d:    d,
+		}
+	})
+
+	o.Spec("it keeps the results across instances", func(t TD) {
+		key := mapreduce.CacheKey{File: "a", FileVersion: "1", AlgName: "x"}
+		t.d.Put(key, map[string][]byte{"k": []byte("v")})
+
+		d, err := cache.NewDir(t.path)
+		Expect(t, err == nil).To(BeTrue())
+
+		result, ok := d.Get(key)
+		Expect(t, ok).To(BeTrue())
+		Expect(t, result).To(Equal(map[string][]byte{"k": []byte("v")}))
+
+		_, ok = d.Get(mapreduce.CacheKey{File: "a", FileVersion: "2", AlgName: "x"})
+		Expect(t, ok).To(BeFalse())
+	})
+
+	o.Spec("it invalidates every result of a file", func(t TD) {
+		a := mapreduce.CacheKey{File: "a"}
+		b := mapreduce.CacheKey{File: "b"}
+		t.d.Put(a, map[string][]byte{})
+		t.d.Put(b, map[string][]byte{})
+		t.d.Invalidate("a")
+
+		_, ok := t.d.Get(a)
+		Expect(t, ok).To(BeFalse())
+		_, ok = t.d.Get(b)
+		Expect(t, ok).To(BeTrue())
+	})
+
+	o.Spec("it keeps the checkpoints when a file is invalidated", func(t TD) {
+		key := mapreduce.CheckpointKey{File: "a", AlgName: "x"}
+		err := t.d.Save(key, mapreduce.Checkpoint{Offset: 7, State: map[string][]byte{"k": []byte("v")}})
+		Expect(t, err == nil).To(BeTrue())
+		t.d.Invalidate("a")
+
+		c, ok, err := t.d.Load(key)
+		Expect(t, err == nil).To(BeTrue())
+		Expect(t, ok).To(BeTrue())
+		Expect(t, c.Offset).To(Equal(int64(7)))
+		Expect(t, c.State).To(Equal(map[string][]byte{"k": []byte("v")}))
+
+		_, ok, err = t.d.Load(mapreduce.CheckpointKey{File: "b"})
+		Expect(t, err == nil).To(BeTrue())
+		Expect(t, ok).To(BeFalse())
+	})
+}
@@ -0,0 +1,76 @@
+// cache provides implementations of mapreduce.ResultCache and
+// mapreduce.CheckpointStore. LRU and Checkpoints keep them in memory. Dir
+// stores both on disk.
+package cache
+
+import (
+	"sync"
+
+	"github.com/poy/mapreduce"
+	"github.com/poy/mapreduce/internal/lru"
+)
+
+// LRU implements mapreduce.ResultCache. It keeps a limited number of results
+// in memory and evicts the least recently used one.
+//
+// An LRU has to be created with NewLRU().
+type LRU struct {
+	mu      sync.Mutex
+	results *lru.Cache
+}
+
+// NewLRU returns a new LRU that holds up to size results.
+func NewLRU(size int) *LRU {
+	return &LRU{
+		results: lru.New(size),
+	}
+}
+
+// Get implements mapreduce.ResultCache.
+func (c *LRU) Get(key mapreduce.CacheKey) (map[string][]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	result, ok := c.results.Get(key)
+	if !ok {
+		return nil, false
+	}
+
+	return clone(result.(map[string][]byte)), true
+}
+
+// Put implements mapreduce.ResultCache.
+func (c *LRU) Put(key mapreduce.CacheKey, result map[string][]byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.results.Add(key, clone(result))
+}
+
+// Invalidate implements mapreduce.ResultCache.
+func (c *LRU) Invalidate(file string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, key := range c.results.Keys() {
+		if key.(mapreduce.CacheKey).File == file {
+			c.results.Remove(key)
+		}
+	}
+}
+
+// Len returns the number of results.
+func (c *LRU) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.results.Len()
+}
+
+// clone copies the map so the caller can not modify the cached result.
+func clone(result map[string][]byte) map[string][]byte {
+	m := make(map[string][]byte, len(result))
+	for k, v := range result {
+		m[k] = append([]byte(nil), v...)
+	}
+	return m
+}
@@ -0,0 +1,64 @@
+package cache_test
+
+import (
+	"testing"
+
+	"github.com/poy/mapreduce"
+	"github.com/poy/mapreduce/cache"
+	"github.com/poy/onpar"
+	. "github.com/poy/onpar/expect"
+	. "github.com/poy/onpar/matchers"
+)
+
+type TL struct {
+	*testing.T
+	c *cache.LRU
+}
+
+func TestLRU(t *testing.T) {
+	t.Parallel()
+	o := onpar.New()
+	defer o.Run(t)
+
+	o.BeforeEach(func(t *testing.T) TL {
+		return TL{
+			T: t,
+			c: cache.NewLRU(2),
+		}
+	})
+
+	o.Spec("it returns the stored result", func(t TL) {
+		key := mapreduce.CacheKey{File: "a", FileVersion: "1"}
+		t.c.Put(key, map[string][]byte{"k": []byte("v")})
+
+		result, ok := t.c.Get(key)
+		Expect(t, ok).To(BeTrue())
+		Expect(t, result).To(Equal(map[string][]byte{"k": []byte("v")}))
+
+		_, ok = t.c.Get(mapreduce.CacheKey{File: "a", FileVersion: "2"})
+		Expect(t, ok).To(BeFalse())
+	})
+
+	o.Spec("it evicts the least recently used result", func(t TL) {
+		a := mapreduce.CacheKey{File: "a"}
+		b := mapreduce.CacheKey{File: "b"}
+		t.c.Put(a, nil)
+		t.c.Put(b, nil)
+		t.c.Get(a)
+		t.c.Put(mapreduce.CacheKey{File: "c"}, nil)
+
+		_, ok := t.c.Get(a)
+		Expect(t, ok).To(BeTrue())
+		_, ok = t.c.Get(b)
+		Expect(t, ok).To(BeFalse())
+		Expect(t, t.c.Len()).To(Equal(2))
+	})
+
+	o.Spec("it invalidates every result of a file", func(t TL) {
+		t.c.Put(mapreduce.CacheKey{File: "a", AlgName: "x"}, nil)
+		t.c.Put(mapreduce.CacheKey{File: "a", AlgName: "y"}, nil)
+		t.c.Invalidate("a")
+
+		Expect(t, t.c.Len()).To(Equal(0))
+	})
+}
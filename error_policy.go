@@ -0,0 +1,131 @@
+package mapreduce
+
+import (
+	"fmt"
+)
+
+// ErrorPolicy decides what an Executor does with records that can not be
+// read or mapped.
+type ErrorPolicy int
+
+const (
+	// AbortOnError aborts the execution on the first bad record. It is the
+	// default.
+	AbortOnError ErrorPolicy = iota
+
+	// SkipOnError skips bad records and counts them.
+	SkipOnError
+
+	// QuarantineOnError skips bad records, counts them and hands them to
+	// the DeadLetterSink (see WithDeadLetterSink). Without a DeadLetterSink
+	// it behaves like SkipOnError.
+	QuarantineOnError
+)
+
+// BadRecord is a record that could not be read or mapped.
+type BadRecord struct {
+	File string
+
+	// Value is the record. It is nil if the record could not be read.
+	Value []byte
+
+	Err error
+}
+
+// DeadLetterSink stores bad records for later inspection.
+type DeadLetterSink interface {
+	// Quarantine stores the bad record. A non-nil error will abort
+	// the operation.
+	Quarantine(record BadRecord) (err error)
+}
+
+// DeadLetterFunc wraps a function into a DeadLetterSink.
+type DeadLetterFunc func(record BadRecord) (err error)
+
+// Quarantine implements the DeadLetterSink interface.
+func (f DeadLetterFunc) Quarantine(record BadRecord) (err error) {
+	return f(record)
+}
+
+// TooManyBadRecordsError is returned when a file has more bad records than
+// allowed.
+type TooManyBadRecordsError struct {
+	File  string
+	Count int
+	Max   int
+
+	// Last is the error of the last bad record.
+	Last error
+}
+
+// Error implements error.
+func (e *TooManyBadRecordsError) Error() string {
+	return fmt.Sprintf("file %s has %d bad records (max %d): %s", e.File, e.Count, e.Max, e.Last)
+}
+
+// maxReadErrors is the number of consecutive errors of a FileSystem reader
+// after which the execution is aborted regardless of the ErrorPolicy.
+const maxReadErrors = 10
+
+// WithErrorPolicy sets the ErrorPolicy. A maxBadRecords greater than 0 aborts
+// the execution once a file has more bad records. A FileSystem reader that
+// fails more than 10 times in a row aborts the execution with its error.
+func WithErrorPolicy(p ErrorPolicy, maxBadRecords int) ExecutorOption {
+	return func(e *Executor) {
+		e.errorPolicy = p
+		e.maxBadRecords = maxBadRecords
+	}
+}
+
+// WithDeadLetterSink sets the DeadLetterSink for the QuarantineOnError
+// policy.
+func WithDeadLetterSink(s DeadLetterSink) ExecutorOption {
+	return func(e *Executor) {
+		e.deadLetters = s
+	}
+}
+
+// WithBadRecordSamples sets how many bad records are kept in the Report. It
+// defaults to 10.
+func WithBadRecordSamples(n int) ExecutorOption {
+	return func(e *Executor) {
+		e.badRecordSamples = n
+	}
+}
+
+// badRecord applies the ErrorPolicy to the bad record. It returns a non-nil
+// error if the execution has to be aborted.
+func (e *Executor) badRecord(x *execution, value []byte, err error) error {
+	if e.errorPolicy == AbortOnError {
+		return err
+	}
+
+	x.logger.Debug("skipping bad record", "err", err)
+	x.report.BadRecords++
+	rec := BadRecord{
+		File:  x.fileName,
+		Value: append([]byte(nil), value...),
+		Err:   err,
+	}
+
+	if len(x.report.Samples) < e.badRecordSamples {
+		x.report.Samples = append(x.report.Samples, rec)
+	}
+
+	if e.errorPolicy == QuarantineOnError && e.deadLetters != nil {
+		if err := e.deadLetters.Quarantine(rec); err != nil {
+			return err
+		}
+	}
+
+	if e.maxBadRecords > 0 && x.report.BadRecords > e.maxBadRecords {
+		return &TooManyBadRecordsError{
+			File:  x.fileName,
+			Count: x.report.BadRecords,
+			Max:   e.maxBadRecords,
+			Last:  err,
+		}
+	}
+
+	return nil
+}
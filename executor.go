@@ -2,6 +2,8 @@ package mapreduce
 
 import (
 	"io"
+	"log/slog"
+	"time"
 
 	"golang.org/x/net/context"
 )
@@ -13,75 +15,338 @@ import (
 type Executor struct {
 	algFetcher AlgorithmFetcher
 	fs         FileSystem
+
+	errorPolicy      ErrorPolicy
+	maxBadRecords    int
+	deadLetters      DeadLetterSink
+	badRecordSamples int
+
+	maxReduceIterations int
+	logger              *slog.Logger
+	metrics             Metrics
+	tracer              Tracer
+	executions          *executions
+	admission           *admission
+	priority            PriorityFunc
+	tenants             *tenants
+	cache               *resultCache
+	checkpoints         CheckpointStore
 }
 
+// ExecutorOption is used to configure a new Executor.
+type ExecutorOption func(*Executor)
+
 // NewExecutor returns a new Executor.
-func NewExecutor(algFetcher AlgorithmFetcher, fs FileSystem) *Executor {
-	return &Executor{
-		algFetcher: algFetcher,
-		fs:         fs,
+func NewExecutor(algFetcher AlgorithmFetcher, fs FileSystem, opts ...ExecutorOption) *Executor {
+	e := &Executor{
+		algFetcher:       algFetcher,
+		fs:               fs,
+		badRecordSamples: 10,
+		logger:           discardLogger(),
+		metrics:          nopMetrics{},
+		tracer:           nopTracer{},
+		executions:       newExecutions(),
+		tenants:          newTenants(),
 	}
+
+	for _, o := range opts {
+		o(e)
+	}
+
+	return e
+}
+
+// WithExecutorMaxReduceIterations limits how often the Reducer is invoked for a single key. A value of 0 (the
+// default) only requires that every invocation shrinks the values.
+func WithExecutorMaxReduceIterations(n int) ExecutorOption {
+	return func(e *Executor) {
+		e.maxReduceIterations = n
+	}
+}
+
+// Report is the outcome of an execution.
+type Report struct {
+	// Result is the reduced data for each key.
+	Result map[string][]byte
+
+	// BadRecords is the number of records that were skipped due to the
+	// ErrorPolicy.
+	BadRecords int
+
+	// Samples holds the first bad records (see WithBadRecordSamples).
+	Samples []BadRecord
+
+	// Mapped and Filtered count the records that were mapped to a key and
+	// to an empty key.
+	Mapped   int
+	Filtered int
+
+	// BytesRead is the size of the records that were read.
+	BytesRead int64
+
+	// Skipped counts the records that were not part of the sample (see
+	// JobSpec.Sample).
+	Skipped int
+
+	// Pruned counts the records that did not match the Predicate of the
+	// algorithm.
+	Pruned int
+}
+
+// CancelJob aborts the executions of the job with the given ID. Later executions of the job are rejected.
+// Executions of a canceled job return ErrJobCanceled.
+func (e *Executor) CancelJob(jobID string) {
+	e.executions.cancel(jobID)
 }
 
 // Execute maps local data from the file (fileName) via the mapper given from the algorithm (algName) and reduces it.
+//
+// A JobSpec in the context or meta information applies its deadline and is checked against the version of the
+// algorithm (see VersionMismatchError). A panic in the Mapper or Reducer is returned as a *PanicError.
 func (e *Executor) Execute(fileName, algName string, ctx context.Context, meta []byte) (result map[string][]byte, err error) {
-	alg, err := e.algFetcher.Alg(algName, meta)
+	report, err := e.ExecuteWithReport(fileName, algName, ctx, meta)
 	if err != nil {
 		return nil, err
 	}
 
-	reader, err := e.fs.Reader(fileName, ctx, meta)
+	return report.Result, nil
+}
+
+// ExecuteWithReport is like Execute, but it also returns a Report. If the execution fails, the Report holds
+// the counts up to the error.
+func (e *Executor) ExecuteWithReport(fileName, algName string, ctx context.Context, meta []byte) (report Report, err error) {
+	spec, ok := ResolveJobSpec(ctx, meta)
+	if ok {
+		var cancel context.CancelFunc
+		ctx, cancel = withJobSpecDeadline(ctx, spec)
+		defer cancel()
+	}
+
+	if spec.Meta != nil {
+		meta = spec.Meta
+	}
+
+	if spec.ID != "" {
+		var done func()
+		ctx, done, err = e.executions.start(spec.ID, ctx)
+		if err != nil {
+			return Report{}, err
+		}
+		defer done()
+	}
+
+	ctx, span := startSpan(e.tracer, ctx, ExecuteSpan,
+		Label{Name: "file", Value: fileName},
+		Label{Name: "alg", Value: algName},
+	)
+	defer func() { span.End(err) }()
+
+	logger := e.logger.With("job", spec.ID, "tenant", spec.Tenant, "alg", algName, "file", fileName)
+	labels := []Label{
+		{Name: "component", Value: ExecutorComponent},
+		{Name: "alg", Value: algName},
+	}
+	start := time.Now()
+	defer func() {
+		e.metrics.Observe(MetricExecuteSeconds, time.Since(start).Seconds(), labels...)
+		e.metrics.Add(MetricRecordsMapped, float64(report.Mapped), labels...)
+		e.metrics.Add(MetricRecordsFiltered, float64(report.Filtered), labels...)
+		e.metrics.Add(MetricRecordsPruned, float64(report.Pruned), labels...)
+		e.metrics.Add(MetricBytesIn, float64(report.BytesRead), labels...)
+		if err != nil {
+			e.metrics.Add(MetricErrors, 1, labels...)
+			attrs := []interface{}{"duration", time.Since(start), "err", err}
+			if perr, ok := err.(*PanicError); ok {
+				attrs = append(attrs, "stack", string(perr.Stack))
+			}
+			logger.Warn("execution failed", attrs...)
+			return
+		}
+
+		e.metrics.Add(MetricFilesProcessed, 1, labels...)
+		e.metrics.Add(MetricBytesOut, float64(resultBytes(report.Result)), labels...)
+		logger.Debug("execution finished", "duration", time.Since(start), "keys", len(report.Result), "bad_records", report.BadRecords)
+	}()
+
+	tenant, err := e.tenants.start(spec.Tenant)
 	if err != nil {
-		return nil, err
+		return Report{}, err
+	}
+	defer tenant.done()
+
+	if e.admission != nil {
+		var priority int
+		if e.priority != nil {
+			priority = e.priority(spec, algName)
+		}
+
+		if err := e.admission.acquire(priority, ctx); err != nil {
+			return Report{}, err
+		}
+		defer e.admission.release()
 	}
 
-	m, err := e.consumeFile(alg, reader)
+	alg, err := e.algFetcher.Alg(algName, meta)
 	if err != nil {
-		return nil, err
+		return Report{}, err
 	}
 
-	result = make(map[string][]byte)
-	for key, values := range m {
-		for len(values) > 1 {
-			values, err = alg.Reduce(values)
-			if err != nil {
-				return nil, err
-			}
+	if err := checkVersion(algName, spec.AlgVersion, alg); err != nil {
+		return Report{}, err
+	}
+
+	key, cacheable := e.cache.key(e.fs, fileName, algName, alg.Version, ctx, meta)
+	if cacheable {
+		if result, ok := e.cache.get(key); ok {
+			e.metrics.Add(MetricCacheHits, 1, labels...)
+			report.Result = result
+			return report, nil
 		}
+		e.metrics.Add(MetricCacheMisses, 1, labels...)
+	}
 
-		if len(values) == 0 {
-			result[key] = nil
-			continue
+	x := &execution{
+		fileName: fileName,
+		algName:  algName,
+		alg:      alg,
+		tenant:   tenant,
+		sampler:  newRecordSampler(spec.Sample, fileName),
+		report:   &report,
+		logger:   logger,
+	}
+
+	mapCtx, mapSpan := startSpan(e.tracer, ctx, MapSpan)
+	if err := e.openFile(x, mapCtx, meta); err != nil {
+		mapSpan.End(err)
+		return Report{}, err
+	}
+
+	m, err := e.consumeFile(x, ctx)
+	if err != nil && ctx.Err() != nil && e.executions.isCanceled(spec.ID) {
+		err = ErrJobCanceled
+	}
+	mapSpan.End(err)
+	if err != nil {
+		return report, err
+	}
+
+	_, reduceSpan := startSpan(e.tracer, ctx, ReduceSpan)
+	defer func() { reduceSpan.End(err) }()
+
+	result := make(map[string][]byte)
+	for key, values := range m {
+		var iterations int
+		result[key], iterations, err = reduceAll(alg, values, algName, fileName, key, e.maxReduceIterations)
+		e.metrics.Observe(MetricReduceIterations, float64(iterations), labels...)
+		if err != nil {
+			return report, err
 		}
+	}
+
+	result, err = x.sampler.scale(alg.Reducer, result, algName, fileName)
+	if err != nil {
+		return report, err
+	}
 
-		result[key] = values[0]
+	if err := x.inc.merge(alg, result, algName, fileName, e.maxReduceIterations); err != nil {
+		return report, err
 	}
+	report.Result = result
+
+	if err := x.inc.save(result); err != nil {
+		logger.Warn("saving the checkpoint failed", "err", err)
+	}
+
+	if cacheable {
+		e.cache.put(key, result)
+	}
+
+	return report, nil
+}
 
-	return result, nil
+// execution holds the state of a single execution.
+type execution struct {
+	fileName string
+	algName  string
+	alg      Algorithm
+	tenant   *tenantState
+	sampler  *recordSampler
+	report   *Report
+	logger   *slog.Logger
+
+	// reader, match and inc are set by openFile.
+	reader func() ([]byte, error)
+	match  func([]byte) bool
+	inc    *incremental
 }
 
-// consumeFile maps data from the reader to the according keys.
-func (e *Executor) consumeFile(alg Mapper, reader func() ([]byte, error)) (map[string][][]byte, error) {
+// consumeFile maps data from the reader to the according keys. Bad records (including records that cause
+// the Predicate or Mapper to panic) are handled according to the ErrorPolicy.
+func (e *Executor) consumeFile(x *execution, ctx context.Context) (map[string][][]byte, error) {
 	m := make(map[string][][]byte)
+	var readErrors int
 	for {
-		data, err := reader()
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		value, err := x.reader()
 		if err == io.EOF {
 			return m, nil
 		}
 
 		if err != nil {
+			// A reader that keeps failing would never reach io.EOF.
+			if readErrors++; readErrors > maxReadErrors {
+				return nil, err
+			}
+
+			if err := e.badRecord(x, nil, err); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		readErrors = 0
+
+		x.report.BytesRead += int64(len(value))
+		if err := x.tenant.record(len(value), ctx); err != nil {
 			return nil, err
 		}
 
-		key, data, err := alg.Map(data)
+		if x.match != nil {
+			ok, err := safeMatch(x.match, value, x.algName, x.fileName)
+			if err != nil {
+				if err := e.badRecord(x, value, err); err != nil {
+					return nil, err
+				}
+				continue
+			}
+
+			if !ok {
+				x.report.Pruned++
+				continue
+			}
+		}
+
+		if !x.sampler.keep() {
+			x.report.Skipped++
+			continue
+		}
+
+		key, data, err := safeMap(x.alg, value, x.algName, x.fileName)
 		if err != nil {
-			return nil, err
+			if err := e.badRecord(x, value, err); err != nil {
+				return nil, err
+			}
+			continue
 		}
 
 		if len(key) == 0 {
+			x.report.Filtered++
 			continue
 		}
 
+		x.report.Mapped++
 		m[key] = append(m[key], data)
 	}
 }
@@ -1,14 +1,21 @@
 package mapreduce_test
 
 import (
+	"bytes"
 	"context"
 	"fmt"
 	"io"
+	"log/slog"
+	"runtime"
+	"strconv"
 	"testing"
 	"time"
 
 	"github.com/poy/eachers/testhelpers"
 	"github.com/poy/mapreduce"
+	"github.com/poy/mapreduce/cache"
+	"github.com/poy/mapreduce/metrics"
+	"github.com/poy/mapreduce/tracing"
 	"github.com/poy/onpar"
 	. "github.com/poy/onpar/expect"
 	. "github.com/poy/onpar/matchers"
@@ -21,6 +28,7 @@ type TE struct {
 	mockReducer    *mockReducer
 	mockMapper     *mockMapper
 	mockAlgFetcher *mockAlgorithmFetcher
+	algFetcher     blockingAlgFetcher
 }
 
 func TestExecutor(t *testing.T) {
@@ -41,6 +49,7 @@ func TestExecutor(t *testing.T) {
 			T:              t,
 			mockMapper:     mockMapper,
 			mockReducer:    mockReducer,
+			mockAlgFetcher: mockAlgFetcher,
 			mockFileSystem: mockFileSystem,
 			e:              mapreduce.NewExecutor(mockAlgFetcher, mockFileSystem),
 		}
@@ -81,6 +90,257 @@ func TestExecutor(t *testing.T) {
 					))
 				})
 
+				o.Spec("it passes the JobSpec to the FileSystem", func(t TE) {
+					meta := mapreduce.JobSpec{ID: "some-id"}.Marshal()
+					t.e.Execute("file", "a", context.Background(), meta)
+
+					var ctx context.Context
+					Expect(t, t.mockFileSystem.ReaderInput.Ctx).To(Chain(Receive(), Fetch(&ctx)))
+					spec, ok := mapreduce.JobSpecFromContext(ctx)
+					Expect(t, ok).To(BeTrue())
+					Expect(t, spec.ID).To(Equal("some-id"))
+				})
+
+				o.Spec("it unwraps the ad-hoc meta information of the JobSpec", func(t TE) {
+					meta := mapreduce.JobSpec{ID: "some-id", Meta: []byte("some-meta")}.Marshal()
+					t.e.Execute("file", "a", context.Background(), meta)
+
+					Expect(t, t.mockAlgFetcher.AlgInput.Meta).To(Chain(
+						Receive(), Equal([]byte("some-meta")),
+					))
+					Expect(t, t.mockFileSystem.ReaderInput.Meta).To(Chain(
+						Receive(), Equal([]byte("some-meta")),
+					))
+				})
+
+				o.Spec("it rejects a different algorithm version", func(t TE) {
+					e := mapreduce.NewExecutor(mapreduce.AlgFetcherMap{
+						"a": {Mapper: t.mockMapper, Reducer: t.mockReducer, Version: "v1"},
+					}, t.mockFileSystem)
+
+					meta := mapreduce.JobSpec{AlgVersion: "v2"}.Marshal()
+					_, err := e.Execute("file", "a", context.Background(), meta)
+
+					verr, ok := err.(*mapreduce.VersionMismatchError)
+					Expect(t, ok).To(BeTrue())
+					Expect(t, verr.Expected).To(Equal("v2"))
+					Expect(t, verr.Actual).To(Equal("v1"))
+					Expect(t, t.mockFileSystem.ReaderCalled).To(Always(HaveLen(0)))
+				})
+
+				o.Spec("it accepts the expected algorithm version", func(t TE) {
+					e := mapreduce.NewExecutor(mapreduce.AlgFetcherMap{
+						"a": {Mapper: t.mockMapper, Reducer: t.mockReducer, Version: "v1"},
+					}, t.mockFileSystem)
+
+					meta := mapreduce.JobSpec{AlgVersion: "v1"}.Marshal()
+					_, err := e.Execute("file", "a", context.Background(), meta)
+					Expect(t, err == nil).To(BeTrue())
+				})
+
+				o.Spec("it writes structured logs", func(t TE) {
+					var buf bytes.Buffer
+					e := mapreduce.NewExecutor(
+						mapreduce.AlgFetcherMap{"a": {Mapper: t.mockMapper, Reducer: t.mockReducer}},
+						t.mockFileSystem,
+						mapreduce.WithExecutorLogger(slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{
+							Level: slog.LevelDebug,
+						}))),
+					)
+
+					meta := mapreduce.JobSpec{ID: "some-id"}.Marshal()
+					e.Execute("file", "a", context.Background(), meta)
+					Expect(t, buf.String()).To(ContainSubstring("job=some-id"))
+					Expect(t, buf.String()).To(ContainSubstring("alg=a"))
+					Expect(t, buf.String()).To(ContainSubstring("file=file"))
+					Expect(t, buf.String()).To(ContainSubstring("duration="))
+				})
+
+				o.Spec("it records metrics", func(t TE) {
+					p := metrics.NewPrometheus()
+					e := mapreduce.NewExecutor(
+						mapreduce.AlgFetcherMap{"a": {Mapper: t.mockMapper, Reducer: t.mockReducer}},
+						t.mockFileSystem,
+						mapreduce.WithExecutorMetrics(p),
+					)
+
+					e.Execute("file", "a", context.Background(), nil)
+
+					var buf bytes.Buffer
+					p.WriteTo(&buf)
+					Expect(t, buf.String()).To(ContainSubstring(`mapreduce_files_processed_total{alg="a",component="executor"} 1`))
+					Expect(t, buf.String()).To(ContainSubstring(`mapreduce_records_mapped_total{alg="a",component="executor"} 3`))
+					Expect(t, buf.String()).To(ContainSubstring(`mapreduce_bytes_in_total{alg="a",component="executor"} 3`))
+					Expect(t, buf.String()).To(ContainSubstring(`mapreduce_bytes_out_total{alg="a",component="executor"} 1`))
+					Expect(t, buf.String()).To(ContainSubstring(`mapreduce_reduce_iterations_sum{alg="a",component="executor"} 1`))
+					Expect(t, buf.String()).To(ContainSubstring(`mapreduce_execute_seconds_count{alg="a",component="executor"} 1`))
+				})
+
+				o.Spec("it continues the trace of the JobSpec", func(t TE) {
+					r := tracing.NewRecorder()
+					e := mapreduce.NewExecutor(
+						mapreduce.AlgFetcherMap{"a": {Mapper: t.mockMapper, Reducer: t.mockReducer}},
+						t.mockFileSystem,
+						mapreduce.WithExecutorTracer(r),
+					)
+
+					parent := r.Start(context.Background(), "coordinator")
+					meta := mapreduce.JobSpec{Traceparent: parent.SpanContext().Traceparent()}.Marshal()
+					e.Execute("file", "a", context.Background(), meta)
+
+					execute := r.Find(mapreduce.ExecuteSpan)
+					Expect(t, execute).To(HaveLen(1))
+					Expect(t, execute[0].Parent).To(Equal(parent.SpanContext()))
+
+					Expect(t, r.Find(mapreduce.MapSpan)).To(HaveLen(1))
+					Expect(t, r.Find(mapreduce.MapSpan)[0].Parent).To(Equal(execute[0].SpanContext))
+					Expect(t, r.Find(mapreduce.ReduceSpan)).To(HaveLen(1))
+					Expect(t, r.Find(mapreduce.ReduceSpan)[0].Parent).To(Equal(execute[0].SpanContext))
+				})
+
+				o.Spec("it rejects executions of a canceled job", func(t TE) {
+					t.e.CancelJob("some-id")
+
+					meta := mapreduce.JobSpec{ID: "some-id"}.Marshal()
+					_, err := t.e.Execute("file", "a", context.Background(), meta)
+					Expect(t, err).To(Equal(mapreduce.ErrJobCanceled))
+					Expect(t, t.mockFileSystem.ReaderCalled).To(Always(HaveLen(0)))
+
+					meta = mapreduce.JobSpec{ID: "other-id"}.Marshal()
+					_, err = t.e.Execute("file", "a", context.Background(), meta)
+					Expect(t, err == nil).To(BeTrue())
+				})
+
+				o.Spec("it aborts the execution when the job is canceled", func(t TE) {
+					var e *mapreduce.Executor
+					var mapped int
+					e = mapreduce.NewExecutor(mapreduce.AlgFetcherMap{
+						"a": {
+							Mapper: mapreduce.MapFunc(func(value []byte) (string, []byte, error) {
+								mapped++
+								e.CancelJob("some-id")
+								return "key", value, nil
+							}),
+							Reducer: t.mockReducer,
+						},
+					}, t.mockFileSystem)
+
+					meta := mapreduce.JobSpec{ID: "some-id"}.Marshal()
+					_, err := e.Execute("file", "a", context.Background(), meta)
+					Expect(t, err).To(Equal(mapreduce.ErrJobCanceled))
+					Expect(t, mapped).To(Equal(1))
+				})
+
+				o.Spec("it reports the usage of each tenant", func(t TE) {
+					e := mapreduce.NewExecutor(
+						mapreduce.AlgFetcherMap{"a": {Mapper: t.mockMapper, Reducer: t.mockReducer}},
+						t.mockFileSystem,
+					)
+
+					meta := mapreduce.JobSpec{Tenant: "some-tenant"}.Marshal()
+					_, err := e.Execute("file", "a", context.Background(), meta)
+					Expect(t, err == nil).To(BeTrue())
+
+					usage := e.TenantUsage()["some-tenant"]
+					Expect(t, usage.Executions).To(Equal(int64(1)))
+					Expect(t, usage.Running).To(Equal(0))
+					Expect(t, usage.Records).To(Equal(int64(3)))
+					Expect(t, usage.BytesRead).To(Equal(int64(3)))
+
+					Expect(t, e.ResetTenantUsage()["some-tenant"].Records).To(Equal(int64(3)))
+					Expect(t, e.TenantUsage()["some-tenant"].Records).To(Equal(int64(0)))
+				})
+
+				o.Spec("it rejects a tenant that read too many bytes", func(t TE) {
+					e := mapreduce.NewExecutor(
+						mapreduce.AlgFetcherMap{"a": {Mapper: t.mockMapper, Reducer: t.mockReducer}},
+						t.mockFileSystem,
+						mapreduce.WithTenantQuota("some-tenant", mapreduce.TenantQuota{MaxBytesRead: 2}),
+					)
+
+					meta := mapreduce.JobSpec{Tenant: "some-tenant"}.Marshal()
+					_, err := e.Execute("file", "a", context.Background(), meta)
+					qerr, ok := err.(*mapreduce.QuotaError)
+					Expect(t, ok).To(BeTrue())
+					Expect(t, qerr.Tenant).To(Equal("some-tenant"))
+					Expect(t, qerr.Quota).To(Equal("MaxBytesRead"))
+					Expect(t, e.TenantUsage()["some-tenant"].Rejected).To(Equal(int64(1)))
+				})
+
+				o.Spec("it throttles the records of a tenant", func(t TE) {
+					e := mapreduce.NewExecutor(
+						mapreduce.AlgFetcherMap{"a": {Mapper: t.mockMapper, Reducer: t.mockReducer}},
+						t.mockFileSystem,
+						mapreduce.WithDefaultTenantQuota(mapreduce.TenantQuota{RecordsPerSecond: 20}),
+					)
+
+					start := time.Now()
+					_, err := e.Execute("file", "a", context.Background(), nil)
+					Expect(t, err == nil).To(BeTrue())
+					elapsed := time.Since(start)
+
+					// Each of the 3 records reserves 50ms, the first one starts
+					// right away. Timers do not fire early.
+					Expect(t, elapsed >= 2*50*time.Millisecond).To(BeTrue())
+
+					throttled := e.TenantUsage()[""].Throttled
+					Expect(t, throttled > 0).To(BeTrue())
+					Expect(t, throttled <= elapsed).To(BeTrue())
+				})
+
+				o.Spec("it caches the result for each version of the file", func(t TE) {
+					c := cache.NewLRU(10)
+					fs := versionedFileSystem{FileSystem: t.mockFileSystem, versions: map[string]string{"file": "1"}}
+					e := mapreduce.NewExecutor(
+						mapreduce.AlgFetcherMap{"a": {Mapper: t.mockMapper, Reducer: t.mockReducer}},
+						fs,
+						mapreduce.WithExecutorCache(c),
+					)
+
+					first, err := e.Execute("file", "a", context.Background(), mapreduce.JobSpec{ID: "job-a"}.Marshal())
+					Expect(t, err == nil).To(BeTrue())
+
+					second, err := e.Execute("file", "a", context.Background(), mapreduce.JobSpec{ID: "job-b"}.Marshal())
+					Expect(t, err == nil).To(BeTrue())
+					Expect(t, second).To(Equal(first))
+					Expect(t, t.mockFileSystem.ReaderCalled).To(HaveLen(1))
+
+					fs.versions["file"] = "2"
+					t.mockFileSystem.ReaderOutput.Reader <- func() ([]byte, error) {
+						return nil, io.EOF
+					}
+					third, err := e.Execute("file", "a", context.Background(), nil)
+					Expect(t, err == nil).To(BeTrue())
+					Expect(t, third).To(HaveLen(0))
+					Expect(t, t.mockFileSystem.ReaderCalled).To(HaveLen(2))
+					Expect(t, c.Len()).To(Equal(1))
+				})
+
+				o.Spec("it does not cache results across params", func(t TE) {
+					fs := versionedFileSystem{FileSystem: t.mockFileSystem, versions: map[string]string{"file": "1"}}
+					e := mapreduce.NewExecutor(
+						mapreduce.AlgFetcherMap{"a": {Mapper: t.mockMapper, Reducer: t.mockReducer}},
+						fs,
+						mapreduce.WithExecutorCache(cache.NewLRU(10)),
+					)
+
+					e.Execute("file", "a", context.Background(), nil)
+					t.mockFileSystem.ReaderOutput.Reader <- func() ([]byte, error) {
+						return nil, io.EOF
+					}
+					meta := mapreduce.JobSpec{Params: map[string]string{"a": "b"}}.Marshal()
+					e.Execute("file", "a", context.Background(), meta)
+					Expect(t, t.mockFileSystem.ReaderCalled).To(HaveLen(2))
+
+					// The JobSpec of the context is used first.
+					t.mockFileSystem.ReaderOutput.Reader <- func() ([]byte, error) {
+						return nil, io.EOF
+					}
+					ctx := mapreduce.WithJobSpec(context.Background(), mapreduce.JobSpec{Params: map[string]string{"a": "c"}})
+					e.Execute("file", "a", ctx, meta)
+					Expect(t, t.mockFileSystem.ReaderCalled).To(HaveLen(3))
+				})
+
 				o.Spec("it uses mapper for each value in file", func(t TE) {
 					t.e.Execute("file", "a", context.Background(), nil)
 					s := toSliceBytes(t.mockMapper.MapInput.Value, 3)
@@ -126,6 +386,148 @@ func TestExecutor(t *testing.T) {
 			})
 		})
 
+		o.Group("when the algorithm panics", func() {
+			o.Spec("it returns a PanicError for the mapper", func(t TE) {
+				e := mapreduce.NewExecutor(mapreduce.AlgFetcherMap{"a": {
+					Mapper: mapreduce.MapFunc(func([]byte) (string, []byte, error) {
+						panic("some-panic")
+					}),
+				}}, t.mockFileSystem)
+
+				_, err := e.Execute("file", "a", context.Background(), nil)
+				perr, ok := err.(*mapreduce.PanicError)
+				Expect(t, ok).To(BeTrue())
+				Expect(t, perr.AlgName).To(Equal("a"))
+				Expect(t, perr.File).To(Equal("file"))
+				Expect(t, perr.Value).To(Equal("some-panic"))
+				Expect(t, len(perr.Stack) > 0).To(BeTrue())
+				Expect(t, perr.Error()).To(Not(ContainSubstring("\n")))
+			})
+
+			o.Spec("it returns a PanicError for the reducer", func(t TE) {
+				e := mapreduce.NewExecutor(mapreduce.AlgFetcherMap{"a": {
+					Mapper: mapreduce.MapFunc(func(v []byte) (string, []byte, error) {
+						return "key", v, nil
+					}),
+					Reducer: mapreduce.ReduceFunc(func([][]byte) ([][]byte, error) {
+						panic("some-panic")
+					}),
+				}}, t.mockFileSystem)
+
+				_, err := e.Execute("file", "a", context.Background(), nil)
+				perr, ok := err.(*mapreduce.PanicError)
+				Expect(t, ok).To(BeTrue())
+				Expect(t, perr.Key).To(Equal("key"))
+			})
+		})
+
+		o.Group("when the reducer does not converge", func() {
+			o.Spec("it returns a NonConvergingError when the values do not shrink", func(t TE) {
+				e := mapreduce.NewExecutor(mapreduce.AlgFetcherMap{"a": {
+					Mapper: mapreduce.MapFunc(func(v []byte) (string, []byte, error) {
+						return "key", v, nil
+					}),
+					Reducer: mapreduce.ReduceFunc(func(v [][]byte) ([][]byte, error) {
+						return v, nil
+					}),
+				}}, t.mockFileSystem)
+
+				_, err := e.Execute("file", "a", context.Background(), nil)
+				nerr, ok := err.(*mapreduce.NonConvergingError)
+				Expect(t, ok).To(BeTrue())
+				Expect(t, nerr.File).To(Equal("file"))
+				Expect(t, nerr.Key).To(Equal("key"))
+				Expect(t, nerr.Input).To(Equal(3))
+			})
+
+			o.Spec("it returns a NonConvergingError after the maximum iterations", func(t TE) {
+				e := mapreduce.NewExecutor(mapreduce.AlgFetcherMap{"a": {
+					Mapper: mapreduce.MapFunc(func(v []byte) (string, []byte, error) {
+						return "key", v, nil
+					}),
+					Reducer: mapreduce.ReduceFunc(func(v [][]byte) ([][]byte, error) {
+						return v[1:], nil
+					}),
+				}}, t.mockFileSystem, mapreduce.WithExecutorMaxReduceIterations(1))
+
+				_, err := e.Execute("file", "a", context.Background(), nil)
+				nerr, ok := err.(*mapreduce.NonConvergingError)
+				Expect(t, ok).To(BeTrue())
+				Expect(t, nerr.Iterations).To(Equal(1))
+			})
+		})
+
+		o.Group("when the mapper returns an error for some records", func() {
+			o.BeforeEach(func(t TE) TE {
+				for _, k := range []string{"", "", "key"} {
+					t.mockMapper.MapOutput.Key <- k
+				}
+				for _, o := range []string{"", "", "c"} {
+					t.mockMapper.MapOutput.Output <- []byte(o)
+				}
+				t.mockMapper.MapOutput.Err <- fmt.Errorf("some-error")
+				t.mockMapper.MapOutput.Err <- fmt.Errorf("some-error")
+				close(t.mockMapper.MapOutput.Err)
+
+				testhelpers.AlwaysReturn(t.mockReducer.ReduceOutput.Reduced, [][]byte{[]byte("c")})
+				close(t.mockReducer.ReduceOutput.Err)
+				return t
+			})
+
+			o.Spec("it aborts by default", func(t TE) {
+				_, err := t.e.Execute("file", "a", context.Background(), nil)
+				Expect(t, err == nil).To(BeFalse())
+			})
+
+			o.Spec("it skips the bad records", func(t TE) {
+				e := mapreduce.NewExecutor(
+					mapreduce.AlgFetcherMap{"a": {Mapper: t.mockMapper, Reducer: t.mockReducer}},
+					t.mockFileSystem,
+					mapreduce.WithErrorPolicy(mapreduce.SkipOnError, 0),
+					mapreduce.WithBadRecordSamples(1),
+				)
+
+				report, err := e.ExecuteWithReport("file", "a", context.Background(), nil)
+				Expect(t, err == nil).To(BeTrue())
+				Expect(t, report.Result).To(Equal(map[string][]byte{"key": []byte("c")}))
+				Expect(t, report.BadRecords).To(Equal(2))
+				Expect(t, report.Samples).To(HaveLen(1))
+				Expect(t, report.Samples[0].File).To(Equal("file"))
+				Expect(t, report.Samples[0].Value).To(Equal([]byte("a")))
+			})
+
+			o.Spec("it quarantines the bad records", func(t TE) {
+				var quarantined []mapreduce.BadRecord
+				e := mapreduce.NewExecutor(
+					mapreduce.AlgFetcherMap{"a": {Mapper: t.mockMapper, Reducer: t.mockReducer}},
+					t.mockFileSystem,
+					mapreduce.WithErrorPolicy(mapreduce.QuarantineOnError, 0),
+					mapreduce.WithDeadLetterSink(mapreduce.DeadLetterFunc(func(r mapreduce.BadRecord) error {
+						quarantined = append(quarantined, r)
+						return nil
+					})),
+				)
+
+				_, err := e.Execute("file", "a", context.Background(), nil)
+				Expect(t, err == nil).To(BeTrue())
+				Expect(t, quarantined).To(HaveLen(2))
+				Expect(t, quarantined[1].Value).To(Equal([]byte("b")))
+			})
+
+			o.Spec("it aborts when there are too many bad records", func(t TE) {
+				e := mapreduce.NewExecutor(
+					mapreduce.AlgFetcherMap{"a": {Mapper: t.mockMapper, Reducer: t.mockReducer}},
+					t.mockFileSystem,
+					mapreduce.WithErrorPolicy(mapreduce.SkipOnError, 1),
+				)
+
+				_, err := e.Execute("file", "a", context.Background(), nil)
+				terr, ok := err.(*mapreduce.TooManyBadRecordsError)
+				Expect(t, ok).To(BeTrue())
+				Expect(t, terr.Count).To(Equal(2))
+			})
+		})
+
 		o.Group("when the mapper returns an error", func() {
 			o.BeforeEach(func(t TE) TE {
 				close(t.mockMapper.MapOutput.Output)
@@ -141,6 +543,299 @@ func TestExecutor(t *testing.T) {
 		})
 	})
 
+	o.Group("when the executor is overloaded", func() {
+		o.BeforeEach(func(t TE) TE {
+			t.algFetcher = blockingAlgFetcher{
+				calls:   make(chan string, 10),
+				release: make(chan struct{}),
+			}
+			return t
+		})
+
+		o.Spec("it rejects executions", func(t TE) {
+			e := mapreduce.NewExecutor(t.algFetcher, routeFileSystem{}, mapreduce.WithWorkers(1, 0))
+			go e.Execute("file", "block", context.Background(), nil)
+			Expect(t, <-t.algFetcher.calls).To(Equal("block"))
+
+			_, err := e.Execute("file", "a", context.Background(), nil)
+			busy, ok := err.(*mapreduce.BusyError)
+			Expect(t, ok).To(BeTrue())
+			Expect(t, busy.Running).To(Equal(1))
+
+			close(t.algFetcher.release)
+		})
+
+		o.Spec("it queues executions by priority", func(t TE) {
+			e := mapreduce.NewExecutor(t.algFetcher, routeFileSystem{},
+				mapreduce.WithWorkers(1, 2),
+				mapreduce.WithPriority(mapreduce.AlgPriority(map[string]int{"high": 1})),
+			)
+			go e.Execute("file", "block", context.Background(), nil)
+			Expect(t, <-t.algFetcher.calls).To(Equal("block"))
+
+			done := make(chan error, 3)
+			for i, alg := range []string{"low", "high"} {
+				go func(alg string) {
+					_, err := e.Execute("file", alg, context.Background(), nil)
+					done <- err
+				}(alg)
+				for e.Queued() <= i {
+					runtime.Gosched()
+				}
+			}
+
+			close(t.algFetcher.release)
+			Expect(t, <-t.algFetcher.calls).To(Equal("high"))
+			Expect(t, <-t.algFetcher.calls).To(Equal("low"))
+			Expect(t, <-done == nil).To(BeTrue())
+			Expect(t, <-done == nil).To(BeTrue())
+		})
+
+		o.Spec("it displaces queued executions with a lower priority", func(t TE) {
+			e := mapreduce.NewExecutor(t.algFetcher, routeFileSystem{},
+				mapreduce.WithWorkers(1, 1),
+				mapreduce.WithPriority(mapreduce.AlgPriority(map[string]int{"high": 1})),
+			)
+			go e.Execute("file", "block", context.Background(), nil)
+			Expect(t, <-t.algFetcher.calls).To(Equal("block"))
+
+			low := make(chan error, 1)
+			go func() {
+				_, err := e.Execute("file", "low", context.Background(), nil)
+				low <- err
+			}()
+			for e.Queued() == 0 {
+				runtime.Gosched()
+			}
+
+			go e.Execute("file", "high", context.Background(), nil)
+			_, ok := (<-low).(*mapreduce.BusyError)
+			Expect(t, ok).To(BeTrue())
+
+			close(t.algFetcher.release)
+			Expect(t, <-t.algFetcher.calls).To(Equal("high"))
+		})
+
+		o.Spec("it rejects a tenant that runs too many files", func(t TE) {
+			e := mapreduce.NewExecutor(t.algFetcher, routeFileSystem{},
+				mapreduce.WithTenantQuota("some-tenant", mapreduce.TenantQuota{MaxConcurrentFiles: 1}),
+			)
+			meta := mapreduce.JobSpec{Tenant: "some-tenant"}.Marshal()
+			go e.Execute("file", "block", context.Background(), meta)
+			Expect(t, <-t.algFetcher.calls).To(Equal("block"))
+
+			_, err := e.Execute("file", "a", context.Background(), meta)
+			qerr, ok := err.(*mapreduce.QuotaError)
+			Expect(t, ok).To(BeTrue())
+			Expect(t, qerr.Quota).To(Equal("MaxConcurrentFiles"))
+
+			other := mapreduce.JobSpec{Tenant: "other-tenant"}.Marshal()
+			_, err = e.Execute("file", "a", context.Background(), other)
+			Expect(t, err == nil).To(BeTrue())
+
+			usage := e.TenantUsage()["some-tenant"]
+			Expect(t, usage.Running).To(Equal(1))
+			Expect(t, usage.Rejected).To(Equal(int64(1)))
+
+			close(t.algFetcher.release)
+		})
+
+		o.Spec("it gives up waiting when the context is done", func(t TE) {
+			e := mapreduce.NewExecutor(t.algFetcher, routeFileSystem{}, mapreduce.WithWorkers(1, 1))
+			go e.Execute("file", "block", context.Background(), nil)
+			Expect(t, <-t.algFetcher.calls).To(Equal("block"))
+
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+			defer cancel()
+			_, err := e.Execute("file", "a", ctx, nil)
+			Expect(t, err).To(Equal(context.DeadlineExceeded))
+
+			close(t.algFetcher.release)
+			_, err = e.Execute("file", "a", context.Background(), nil)
+			Expect(t, err == nil).To(BeTrue())
+		})
+	})
+
+	o.Group("when the algorithm has a predicate", func() {
+		o.BeforeEach(func(t TE) TE {
+			records := [][]byte{[]byte("error"), []byte("info"), []byte("error")}
+			t.mockFileSystem.ReaderOutput.Reader <- func() ([]byte, error) {
+				if len(records) == 0 {
+					return nil, io.EOF
+				}
+				defer func() { records = records[1:] }()
+				return records[0], nil
+			}
+			close(t.mockFileSystem.ReaderOutput.Err)
+			return t
+		})
+
+		predicate := &mapreduce.Predicate{
+			Conditions: []mapreduce.Condition{{Field: "level", Op: mapreduce.Eq, Value: "error"}},
+			Match: func(value []byte) bool {
+				return string(value) == "error"
+			},
+		}
+
+		o.Spec("it drops the records that do not match", func(t TE) {
+			alg := countAlg()
+			alg.Predicate = predicate
+			e := mapreduce.NewExecutor(mapreduce.AlgFetcherMap{"count": alg}, t.mockFileSystem)
+
+			report, err := e.ExecuteWithReport("file", "count", context.Background(), nil)
+			Expect(t, err == nil).To(BeTrue())
+			Expect(t, report.Pruned).To(Equal(1))
+			Expect(t, string(report.Result["count"])).To(Equal("2"))
+		})
+
+		o.Spec("it pushes the predicate down to the FileSystem", func(t TE) {
+			alg := countAlg()
+			alg.Predicate = predicate
+			fs := &filterFileSystem{FileSystem: t.mockFileSystem, exact: true}
+			e := mapreduce.NewExecutor(mapreduce.AlgFetcherMap{"count": alg}, fs)
+
+			report, err := e.ExecuteWithReport("file", "count", context.Background(), nil)
+			Expect(t, err == nil).To(BeTrue())
+			Expect(t, fs.predicate.String()).To(Equal(`level = "error"`))
+			Expect(t, report.Pruned).To(Equal(0))
+			Expect(t, string(report.Result["count"])).To(Equal("3"))
+		})
+
+		o.Spec("it handles a panicking predicate as a bad record", func(t TE) {
+			alg := countAlg()
+			alg.Predicate = &mapreduce.Predicate{
+				Match: func(value []byte) bool {
+					if string(value) != "error" {
+						panic("some-panic")
+					}
+					return true
+				},
+			}
+			e := mapreduce.NewExecutor(mapreduce.AlgFetcherMap{"count": alg}, t.mockFileSystem,
+				mapreduce.WithErrorPolicy(mapreduce.SkipOnError, 0),
+			)
+
+			report, err := e.ExecuteWithReport("file", "count", context.Background(), nil)
+			Expect(t, err == nil).To(BeTrue())
+			Expect(t, report.BadRecords).To(Equal(1))
+			_, ok := report.Samples[0].Err.(*mapreduce.PanicError)
+			Expect(t, ok).To(BeTrue())
+		})
+
+		o.Spec("it checks the records of an inexact FileSystem", func(t TE) {
+			alg := countAlg()
+			alg.Predicate = predicate
+			fs := &filterFileSystem{FileSystem: t.mockFileSystem}
+			e := mapreduce.NewExecutor(mapreduce.AlgFetcherMap{"count": alg}, fs)
+
+			report, err := e.ExecuteWithReport("file", "count", context.Background(), nil)
+			Expect(t, err == nil).To(BeTrue())
+			Expect(t, report.Pruned).To(Equal(1))
+		})
+	})
+
+	o.Spec("it maps a sample of the records", func(t TE) {
+		records := 100
+		t.mockFileSystem.ReaderOutput.Reader <- func() ([]byte, error) {
+			if records == 0 {
+				return nil, io.EOF
+			}
+			records--
+			return []byte("x"), nil
+		}
+		close(t.mockFileSystem.ReaderOutput.Err)
+		e := mapreduce.NewExecutor(mapreduce.AlgFetcherMap{"count": countAlg()}, t.mockFileSystem)
+
+		meta := mapreduce.JobSpec{Sample: mapreduce.Sample{RecordFraction: 0.2, Seed: 1}}.Marshal()
+		report, err := e.ExecuteWithReport("file", "count", context.Background(), meta)
+		Expect(t, err == nil).To(BeTrue())
+		Expect(t, report.Skipped > 50).To(BeTrue())
+		Expect(t, report.Mapped+report.Skipped).To(Equal(100))
+		Expect(t, string(report.Result["count"])).To(Equal("100"))
+	})
+
+	o.Spec("it returns a PanicError when the Estimator panics", func(t TE) {
+		records := 10
+		t.mockFileSystem.ReaderOutput.Reader <- func() ([]byte, error) {
+			if records == 0 {
+				return nil, io.EOF
+			}
+			records--
+			return []byte("x"), nil
+		}
+		close(t.mockFileSystem.ReaderOutput.Err)
+		alg := countAlg()
+		alg.Reducer = panicEstimator{}
+		e := mapreduce.NewExecutor(mapreduce.AlgFetcherMap{"count": alg}, t.mockFileSystem)
+
+		meta := mapreduce.JobSpec{Sample: mapreduce.Sample{RecordFraction: 0.5, Seed: 1}}.Marshal()
+		_, err := e.Execute("file", "count", context.Background(), meta)
+		perr, ok := err.(*mapreduce.PanicError)
+		Expect(t, ok).To(BeTrue())
+		Expect(t, perr.Key).To(Equal("count"))
+	})
+
+	o.Spec("it maps only the appended records", func(t TE) {
+		fs := &appendFileSystem{records: map[string][][]byte{"file": {[]byte("a"), []byte("b")}}}
+		var mapped []string
+		count := mapreduce.Algorithm{
+			Mapper: mapreduce.MapFunc(func(value []byte) (string, []byte, error) {
+				mapped = append(mapped, string(value))
+				return "count", []byte("1"), nil
+			}),
+			Reducer: mapreduce.ReduceFunc(func(values [][]byte) ([][]byte, error) {
+				var sum int
+				for _, v := range values {
+					n, _ := strconv.Atoi(string(v))
+					sum += n
+				}
+				return [][]byte{[]byte(strconv.Itoa(sum))}, nil
+			}),
+		}
+		e := mapreduce.NewExecutor(mapreduce.AlgFetcherMap{"count": count}, fs,
+			mapreduce.WithCheckpoints(cache.NewCheckpoints()),
+		)
+
+		result, err := e.Execute("file", "count", context.Background(), nil)
+		Expect(t, err == nil).To(BeTrue())
+		Expect(t, string(result["count"])).To(Equal("2"))
+
+		fs.append("file", []byte("c"))
+		report, err := e.ExecuteWithReport("file", "count", context.Background(), nil)
+		Expect(t, err == nil).To(BeTrue())
+		Expect(t, string(report.Result["count"])).To(Equal("3"))
+		Expect(t, report.Mapped).To(Equal(1))
+		Expect(t, mapped).To(Equal([]string{"a", "b", "c"}))
+
+		// A truncated file is read from the start.
+		fs.records["file"] = [][]byte{[]byte("d")}
+		result, err = e.Execute("file", "count", context.Background(), nil)
+		Expect(t, err == nil).To(BeTrue())
+		Expect(t, string(result["count"])).To(Equal("1"))
+	})
+
+	o.Group("when the reader keeps failing", func() {
+		o.BeforeEach(func(t TE) TE {
+			t.mockFileSystem.ReaderOutput.Reader <- func() ([]byte, error) {
+				return nil, fmt.Errorf("some-error")
+			}
+			close(t.mockFileSystem.ReaderOutput.Err)
+			return t
+		})
+
+		o.Spec("it aborts regardless of the ErrorPolicy", func(t TE) {
+			e := mapreduce.NewExecutor(
+				mapreduce.AlgFetcherMap{"a": {Mapper: t.mockMapper, Reducer: t.mockReducer}},
+				t.mockFileSystem,
+				mapreduce.WithErrorPolicy(mapreduce.SkipOnError, 0),
+			)
+
+			_, err := e.Execute("file", "a", context.Background(), nil)
+			Expect(t, err == nil).To(BeFalse())
+			Expect(t, err.Error()).To(Equal("some-error"))
+		})
+	})
+
 	o.Group("when the filesystem returns an error", func() {
 		o.BeforeEach(func(t TE) TE {
 			close(t.mockFileSystem.ReaderOutput.Reader)
@@ -180,3 +875,68 @@ func toSliceDoubleBytes(c <-chan [][]byte, count int) (results [][][]byte) {
 
 	return results
 }
+
+// appendFileSystem serves append-only files. The offset is the index of the
+// next record.
+type appendFileSystem struct {
+	mapreduce.FileSystem
+	records map[string][][]byte
+}
+
+func (fs *appendFileSystem) append(file string, records ...[]byte) {
+	fs.records[file] = append(fs.records[file], records...)
+}
+
+func (fs *appendFileSystem) ReaderAt(file string, offset int64, ctx context.Context, meta []byte) (func() ([]byte, int64, error), error) {
+	records := fs.records[file]
+	if offset > int64(len(records)) {
+		return nil, mapreduce.ErrInvalidOffset
+	}
+
+	return func() ([]byte, int64, error) {
+		if offset >= int64(len(records)) {
+			return nil, offset, io.EOF
+		}
+		offset++
+		return records[offset-1], offset, nil
+	}, nil
+}
+
+// panicEstimator is a sumReducer whose ParseEstimate panics.
+type panicEstimator struct {
+	sumReducer
+}
+
+func (panicEstimator) ParseEstimate(reduced []byte) (float64, error) {
+	panic("some-panic")
+}
+
+// filterFileSystem records the Predicate and returns every record. The
+// result claims to be exact if exact is set.
+type filterFileSystem struct {
+	mapreduce.FileSystem
+	exact     bool
+	predicate mapreduce.Predicate
+}
+
+func (fs *filterFileSystem) FilteredReader(file string, p mapreduce.Predicate, ctx context.Context, meta []byte) (func() ([]byte, error), bool, error) {
+	fs.predicate = p
+	reader, err := fs.Reader(file, ctx, meta)
+	return reader, fs.exact, err
+}
+
+// blockingAlgFetcher records the requested algorithms. It blocks the
+// algorithm "block" until release is closed.
+type blockingAlgFetcher struct {
+	calls   chan string
+	release chan struct{}
+}
+
+func (f blockingAlgFetcher) Alg(name string, meta []byte) (mapreduce.Algorithm, error) {
+	f.calls <- name
+	if name == "block" {
+		<-f.release
+	}
+
+	return mapreduce.Algorithm{}, nil
+}
@@ -5,7 +5,7 @@ import "golang.org/x/net/context"
 // FileSystem is used to store data local to the node.
 type FileSystem interface {
 	// Files returns a set of files that matches the given route and meta information. A non-nil
-	// error will exit the operation.
+	// error will exit the operation. The routes package provides a standard grammar for routes.
 	Files(route string, ctx context.Context, meta []byte) (files map[string][]string, err error)
 
 	// Reader returns a reader to read data from the given file.
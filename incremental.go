@@ -0,0 +1,161 @@
+package mapreduce
+
+import (
+	"errors"
+	"io"
+
+	"golang.org/x/net/context"
+)
+
+// ErrInvalidOffset is returned by an OffsetFileSystem when the file does not
+// have the given offset (e.g., it was truncated or replaced). The Executor
+// then reads the file from the start.
+var ErrInvalidOffset = errors.New("invalid file offset")
+
+// OffsetFileSystem is an optional extension of a FileSystem for append-only
+// files. It is required for incremental executions (see WithCheckpoints).
+type OffsetFileSystem interface {
+	FileSystem
+
+	// ReaderAt returns a reader that starts at the given offset. Next is the
+	// offset after the returned record. An offset of 0 is the start of the
+	// file.
+	ReaderAt(file string, offset int64, ctx context.Context, meta []byte) (reader func() (data []byte, next int64, err error), err error)
+}
+
+// CheckpointKey identifies the Checkpoint of an algorithm for a file.
+type CheckpointKey struct {
+	File       string
+	AlgName    string
+	AlgVersion string
+
+	// MetaHash is the hash of the meta information and the JobSpec (see
+	// CacheKey).
+	MetaHash string
+}
+
+// Checkpoint is the state of an incremental execution.
+type Checkpoint struct {
+	// Offset is the offset after the last record that was read.
+	Offset int64
+
+	// State is the reduced data for each key up to the Offset.
+	State map[string][]byte
+}
+
+// CheckpointStore stores Checkpoints. It has to be safe for concurrent use.
+// The cache subpackage provides an in-memory and an on-disk implementation.
+type CheckpointStore interface {
+	// Load returns the Checkpoint. It reports false if there is none.
+	Load(key CheckpointKey) (c Checkpoint, ok bool, err error)
+
+	// Save stores the Checkpoint.
+	Save(key CheckpointKey, c Checkpoint) error
+}
+
+// WithCheckpoints enables incremental executions for FileSystems that
+// implement OffsetFileSystem. After each execution, the offset and the
+// result are stored as a Checkpoint of the file and algorithm. The next
+// execution only maps the records after the offset and reduces them into
+// the stored result. Therefore, the Reducer has to accept its own output.
+// The Report only counts the new records.
+func WithCheckpoints(s CheckpointStore) ExecutorOption {
+	return func(e *Executor) {
+		e.checkpoints = s
+	}
+}
+
+// incremental tracks the Checkpoint of an execution.
+type incremental struct {
+	store CheckpointStore
+	key   CheckpointKey
+	cp    Checkpoint
+}
+
+// openFile sets the reader of the execution. For an incremental execution,
+// the reader starts at the offset of the Checkpoint and inc tracks the
+// offset. Otherwise inc is nil and the Predicate of the algorithm is pushed
+// down (see FilterFileSystem).
+func (e *Executor) openFile(x *execution, ctx context.Context, meta []byte) error {
+	ofs, ok := e.fs.(OffsetFileSystem)
+	if e.checkpoints == nil || !ok {
+		var err error
+		x.reader, x.match, err = e.reader(x.fileName, x.alg, ctx, meta)
+		return err
+	}
+
+	if x.alg.Predicate != nil {
+		x.match = x.alg.Predicate.Match
+	}
+
+	inc := &incremental{
+		store: e.checkpoints,
+		key: CheckpointKey{
+			File:       x.fileName,
+			AlgName:    x.algName,
+			AlgVersion: x.alg.Version,
+			MetaHash:   metaHash(ctx, meta),
+		},
+	}
+
+	cp, ok, err := e.checkpoints.Load(inc.key)
+	if err != nil {
+		return err
+	}
+	if ok {
+		inc.cp = cp
+	}
+
+	next, err := ofs.ReaderAt(x.fileName, inc.cp.Offset, ctx, meta)
+	if err == ErrInvalidOffset {
+		inc.cp = Checkpoint{}
+		next, err = ofs.ReaderAt(x.fileName, 0, ctx, meta)
+	}
+	if err != nil {
+		return err
+	}
+
+	x.inc = inc
+	x.reader = func() ([]byte, error) {
+		value, offset, err := next()
+		if err != io.EOF && offset > inc.cp.Offset {
+			inc.cp.Offset = offset
+		}
+		return value, err
+	}
+
+	return nil
+}
+
+// merge reduces the state of the Checkpoint into the result.
+func (inc *incremental) merge(alg Reducer, result map[string][]byte, algName, fileName string, maxIterations int) error {
+	if inc == nil {
+		return nil
+	}
+
+	for key, state := range inc.cp.State {
+		value, ok := result[key]
+		if !ok {
+			result[key] = state
+			continue
+		}
+
+		reduced, _, err := reduceAll(alg, [][]byte{state, value}, algName, fileName, key, maxIterations)
+		if err != nil {
+			return err
+		}
+		result[key] = reduced
+	}
+
+	return nil
+}
+
+// save stores the result with the offset that was reached.
+func (inc *incremental) save(result map[string][]byte) error {
+	if inc == nil {
+		return nil
+	}
+
+	inc.cp.State = result
+	return inc.store.Save(inc.key, inc.cp)
+}
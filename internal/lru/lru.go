@@ -0,0 +1,89 @@
+// lru provides a least recently used cache. It backs the result cache and the
+// caches of compiled and built algorithms.
+package lru
+
+import "container/list"
+
+// Cache holds a limited number of values and evicts the least recently used
+// one. The keys have to be comparable. It is not safe for concurrent use.
+//
+// A Cache has to be created with New().
+type Cache struct {
+	size    int
+	entries *list.List
+	keys    map[interface{}]*list.Element
+}
+
+type entry struct {
+	key   interface{}
+	value interface{}
+}
+
+// New returns a new Cache that holds up to size values. A size of 0 or less
+// disables the cache.
+func New(size int) *Cache {
+	return &Cache{
+		size:    size,
+		entries: list.New(),
+		keys:    make(map[interface{}]*list.Element),
+	}
+}
+
+// Get returns the value and marks it as recently used.
+func (c *Cache) Get(key interface{}) (interface{}, bool) {
+	e, ok := c.keys[key]
+	if !ok {
+		return nil, false
+	}
+	c.entries.MoveToFront(e)
+
+	return e.Value.(*entry).value, true
+}
+
+// Add stores the value and returns the values that were evicted. The
+// value replaces the one with the same key, which is evicted.
+func (c *Cache) Add(key, value interface{}) (evicted []interface{}) {
+	if e, ok := c.keys[key]; ok {
+		evicted = append(evicted, c.remove(e))
+	}
+
+	c.keys[key] = c.entries.PushFront(&entry{key: key, value: value})
+	for c.entries.Len() > c.size {
+		evicted = append(evicted, c.remove(c.entries.Back()))
+	}
+
+	return evicted
+}
+
+// Remove removes the value with the given key and returns it.
+func (c *Cache) Remove(key interface{}) (interface{}, bool) {
+	e, ok := c.keys[key]
+	if !ok {
+		return nil, false
+	}
+
+	return c.remove(e), true
+}
+
+// Keys returns the keys from the most to the least recently used one.
+func (c *Cache) Keys() []interface{} {
+	keys := make([]interface{}, 0, c.entries.Len())
+	for e := c.entries.Front(); e != nil; e = e.Next() {
+		keys = append(keys, e.Value.(*entry).key)
+	}
+
+	return keys
+}
+
+// Len returns the number of values.
+func (c *Cache) Len() int {
+	return c.entries.Len()
+}
+
+func (c *Cache) remove(e *list.Element) interface{} {
+	c.entries.Remove(e)
+	ent := e.Value.(*entry)
+	delete(c.keys, ent.key)
+
+	return ent.value
+}
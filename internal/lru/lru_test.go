@@ -0,0 +1,68 @@
+package lru_test
+
+import (
+	"testing"
+
+	"github.com/poy/mapreduce/internal/lru"
+	"github.com/poy/onpar"
+	. "github.com/poy/onpar/expect"
+	. "github.com/poy/onpar/matchers"
+)
+
+type TL struct {
+	*testing.T
+	c *lru.Cache
+}
+
+func TestCache(t *testing.T) {
+	t.Parallel()
+	o := onpar.New()
+	defer o.Run(t)
+
+	o.BeforeEach(func(t *testing.T) TL {
+		return TL{
+			T: t,
+			c: lru.New(2),
+		}
+	})
+
+	o.Spec("it evicts the least recently used value", func(t TL) {
+		Expect(t, t.c.Add("a", 1)).To(HaveLen(0))
+		Expect(t, t.c.Add("b", 2)).To(HaveLen(0))
+		t.c.Get("a")
+
+		Expect(t, t.c.Add("c", 3)).To(Equal([]interface{}{2}))
+		_, ok := t.c.Get("b")
+		Expect(t, ok).To(BeFalse())
+
+		v, ok := t.c.Get("a")
+		Expect(t, ok).To(BeTrue())
+		Expect(t, v).To(Equal(1))
+		Expect(t, t.c.Len()).To(Equal(2))
+	})
+
+	o.Spec("it evicts the replaced value", func(t TL) {
+		t.c.Add("a", 1)
+		Expect(t, t.c.Add("a", 2)).To(Equal([]interface{}{1}))
+		Expect(t, t.c.Len()).To(Equal(1))
+	})
+
+	o.Spec("it removes values", func(t TL) {
+		t.c.Add("a", 1)
+		t.c.Add("b", 2)
+		Expect(t, t.c.Keys()).To(Equal([]interface{}{"b", "a"}))
+
+		v, ok := t.c.Remove("a")
+		Expect(t, ok).To(BeTrue())
+		Expect(t, v).To(Equal(1))
+		_, ok = t.c.Remove("a")
+		Expect(t, ok).To(BeFalse())
+		Expect(t, t.c.Keys()).To(Equal([]interface{}{"b"}))
+	})
+
+	o.Spec("it does not hold values with a size of 0", func(t TL) {
+		c := lru.New(0)
+		Expect(t, c.Add("a", 1)).To(Equal([]interface{}{1}))
+		Expect(t, c.Len()).To(Equal(0))
+	})
+}
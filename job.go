@@ -0,0 +1,246 @@
+package mapreduce
+
+import (
+	"log/slog"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// job holds the state of a single Calculate.
+type job struct {
+	r       MapReduce
+	algName string
+	spec    JobSpec
+	start   time.Time
+	logger  *slog.Logger
+	cancel  context.CancelFunc
+
+	// wg tracks the goroutines of the files.
+	wg sync.WaitGroup
+
+	mu       sync.Mutex
+	summary  JobSummary
+	canceled bool
+
+	// active counts the running Network.Executes of each node.
+	active map[string]int
+}
+
+func newJob(r MapReduce, algName string, spec JobSpec, cancel context.CancelFunc) *job {
+	return &job{
+		r:       r,
+		algName: algName,
+		spec:    spec,
+		start:   time.Now(),
+		logger:  r.logger.With("job", spec.ID, "tenant", spec.Tenant, "alg", algName),
+		cancel:  cancel,
+		active:  make(map[string]int),
+	}
+}
+
+// info returns the JobInfo of the running job.
+func (j *job) info() JobInfo {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	info := JobInfo{
+		ID:      j.spec.ID,
+		Route:   j.spec.Route,
+		AlgName: j.algName,
+		Caller:  j.spec.Caller,
+		Tenant:  j.spec.Tenant,
+		Status:  JobRunning,
+		Start:   j.start,
+	}
+
+	if j.canceled {
+		info.Status = JobCanceled
+	}
+
+	return info
+}
+
+// markCanceled records that the job was canceled via MapReduce.Cancel.
+func (j *job) markCanceled() {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.canceled = true
+}
+
+func (j *job) isCanceled() bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.canceled
+}
+
+// activeNodes returns the nodes that are calculating files of the job.
+func (j *job) activeNodes() []string {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	var ids []string
+	for id := range j.active {
+		ids = append(ids, id)
+	}
+
+	return ids
+}
+
+// track counts the Network.Execute on the node until the returned function
+// is called.
+func (j *job) track(id string) func() {
+	j.mu.Lock()
+	j.active[id]++
+	j.mu.Unlock()
+
+	return func() {
+		j.mu.Lock()
+		defer j.mu.Unlock()
+
+		j.active[id]--
+		if j.active[id] == 0 {
+			delete(j.active, id)
+		}
+	}
+}
+
+// labels returns the labels of the metrics of the job.
+func (j *job) labels(extra ...Label) []Label {
+	return append([]Label{
+		{Name: "component", Value: CoordinatorComponent},
+		{Name: "alg", Value: j.algName},
+	}, extra...)
+}
+
+// fileResult is the outcome of the calculation for a single file.
+type fileResult struct {
+	file   string
+	result map[string][]byte
+	err    error
+}
+
+// expired reports if the deadline of the job was exceeded. The deadline of a
+// Network.Execute can fire before the context of the job is done.
+func (j *job) expired(ctx context.Context) bool {
+	if ctx.Err() == context.DeadlineExceeded {
+		return true
+	}
+
+	return !j.spec.Deadline.IsZero() && !time.Now().Before(j.spec.Deadline)
+}
+
+// execute runs the calculation for the file on the node. The deadline for the
+// Network.Execute and its span are stored in the context and the JobSpec. It
+// returns a *TimeoutError if the deadline is exceeded, even if the Network
+// does not return. It waits for a slot of the node if WithNodeSlots is used.
+func (j *job) execute(fileName, id string, speculative bool, ctx context.Context) (map[string][]byte, error) {
+	// Waiting for a slot of the node does not count towards the execute timeout.
+	if j.r.slots != nil {
+		if err := j.r.slots.acquire(id, j.spec.ID, ctx); err != nil {
+			if err == context.DeadlineExceeded {
+				return nil, newTimeoutError(map[string]string{fileName: id})
+			}
+			return nil, err
+		}
+		defer j.r.slots.release(id, j.spec.ID)
+	}
+
+	ctx, span := startSpan(j.r.tracer, ctx, NetworkExecuteSpan,
+		Label{Name: "file", Value: fileName},
+		Label{Name: "node", Value: id},
+		Label{Name: "speculative", Value: strconv.FormatBool(speculative)},
+	)
+
+	spec := j.spec
+	spec.Deadline = withTimeout(spec.Deadline, j.r.executeTimeout)
+	if c := span.SpanContext(); c.IsValid() {
+		spec.Traceparent = c.Traceparent()
+	}
+	ctx, cancel := withJobSpecDeadline(ctx, spec)
+	defer cancel()
+
+	j.emit(ProgressEvent{Type: FileStarted, File: fileName, NodeID: id, Speculative: speculative})
+	start := time.Now()
+
+	untrack := j.track(id)
+	defer untrack()
+
+	done := make(chan fileResult, 1)
+	go func() {
+		result, err := j.r.network.Execute(fileName, j.algName, id, ctx, spec.Marshal())
+		done <- fileResult{file: fileName, result: result, err: err}
+	}()
+
+	var res fileResult
+	select {
+	case res = <-done:
+	case <-ctx.Done():
+		res.err = ctx.Err()
+	}
+	j.r.recordOutcome(id, res.err)
+	span.End(res.err)
+
+	if res.err != nil && ctx.Err() == context.DeadlineExceeded {
+		res.err = newTimeoutError(map[string]string{fileName: id})
+	}
+
+	e := ProgressEvent{
+		Type:        FileSucceeded,
+		File:        fileName,
+		NodeID:      id,
+		Duration:    time.Since(start),
+		Keys:        len(res.result),
+		Err:         res.err,
+		Speculative: speculative,
+	}
+	labels := j.labels(Label{Name: "node", Value: id})
+	j.r.metrics.Observe(MetricExecuteSeconds, e.Duration.Seconds(), labels...)
+	if res.err != nil {
+		j.r.metrics.Add(MetricErrors, 1, labels...)
+		e.Type = FileFailed
+		e.Keys = 0
+		j.logger.Warn("calculation failed", "file", fileName, "node", id, "duration", e.Duration, "err", res.err)
+	} else {
+		j.logger.Debug("calculation succeeded", "file", fileName, "node", id, "duration", e.Duration, "keys", e.Keys)
+	}
+	j.emit(e)
+
+	if res.err != nil {
+		return nil, res.err
+	}
+
+	return res.result, nil
+}
+
+// executeReplicas runs the calculation for the file on the node. If the node
+// is busy (see BusyError), the file is executed on the other replicas in
+// turn.
+func (j *job) executeReplicas(fileName, id string, ids []string, speculative bool, ctx context.Context) (map[string][]byte, error) {
+	tried := map[string]bool{id: true}
+	for {
+		result, err := j.execute(fileName, id, speculative, ctx)
+		if !isBusy(err) {
+			return result, err
+		}
+
+		var untried []string
+		for _, other := range ids {
+			if !tried[other] {
+				untried = append(untried, other)
+			}
+		}
+
+		if len(untried) == 0 {
+			return nil, err
+		}
+
+		next := j.r.pickNode(untried, "")
+		j.logger.Info("node is busy, retrying on replica", "file", fileName, "node", next, "busy_node", id)
+		j.emit(ProgressEvent{Type: FileRetried, File: fileName, NodeID: next, Speculative: speculative})
+		tried[next] = true
+		id = next
+	}
+}
@@ -0,0 +1,202 @@
+package mapreduce
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// jobSpecPrefix marks meta information that holds an encoded JobSpec. It
+// allows a JobSpec to be told apart from ad-hoc meta information.
+var jobSpecPrefix = []byte("mapreduce.JobSpec/v1:")
+
+// ErrNotJobSpec is returned by UnmarshalJobSpec when the given data is not
+// an encoded JobSpec (e.g., it is ad-hoc meta information).
+var ErrNotJobSpec = errors.New("meta information is not a JobSpec")
+
+// JobSpec describes a job. It is the typed alternative to passing opaque
+// meta information through the FileSystem, Network and AlgorithmFetcher.
+type JobSpec struct {
+	// ID identifies the job.
+	ID string
+
+	// Route is the route that is given to the FileSystem.
+	Route string
+
+	// AlgName is the name of the algorithm.
+	AlgName string
+
+	// AlgVersion is the expected version of the algorithm. An empty
+	// AlgVersion matches any version.
+	AlgVersion string
+
+	// Params are the parameters for the algorithm.
+	Params map[string]string
+
+	// Deadline is when the job has to be finished. A zero value means
+	// there is no deadline.
+	Deadline time.Time
+
+	// Caller identifies who submitted the job.
+	Caller string
+
+	// Tenant identifies the team the job is run for. Executors apply the
+	// quotas of the tenant (see WithTenantQuota).
+	Tenant string
+
+	// Script is the source of a scripted algorithm (see package script).
+	Script string
+
+	// Traceparent is the parent span of the work on a node in the W3C
+	// traceparent format (see SpanContext).
+	Traceparent string
+
+	// Sample makes the job approximate. Only a fraction of the files and
+	// records is processed.
+	Sample Sample
+
+	// Meta is the ad-hoc meta information of the caller. The Executor hands
+	// it to the FileSystem and AlgorithmFetcher instead of the JobSpec.
+	Meta []byte
+}
+
+// jobSpecWire is the JSON layout of a JobSpec. The deadline is stored as
+// nanoseconds so the encoding does not depend on the time zone.
+type jobSpecWire struct {
+	ID          string            `json:"id,omitempty"`
+	Route       string            `json:"route,omitempty"`
+	AlgName     string            `json:"alg,omitempty"`
+	AlgVersion  string            `json:"alg_version,omitempty"`
+	Params      map[string]string `json:"params,omitempty"`
+	Deadline    int64             `json:"deadline,omitempty"`
+	Caller      string            `json:"caller,omitempty"`
+	Tenant      string            `json:"tenant,omitempty"`
+	Script      string            `json:"script,omitempty"`
+	Traceparent string            `json:"traceparent,omitempty"`
+	Sample      *sampleWire       `json:"sample,omitempty"`
+	Meta        []byte            `json:"meta,omitempty"`
+}
+
+type sampleWire struct {
+	FileFraction   float64 `json:"file_fraction,omitempty"`
+	Stratified     bool    `json:"stratified,omitempty"`
+	RecordFraction float64 `json:"record_fraction,omitempty"`
+	Seed           int64   `json:"seed,omitempty"`
+}
+
+// Marshal returns the canonical encoding of the JobSpec. Equal JobSpecs
+// always have the same encoding. The result can be used as meta
+// information.
+func (s JobSpec) Marshal() []byte {
+	w := jobSpecWire{
+		ID:          s.ID,
+		Route:       s.Route,
+		AlgName:     s.AlgName,
+		AlgVersion:  s.AlgVersion,
+		Params:      s.Params,
+		Caller:      s.Caller,
+		Tenant:      s.Tenant,
+		Script:      s.Script,
+		Traceparent: s.Traceparent,
+		Meta:        s.Meta,
+	}
+
+	if !s.Deadline.IsZero() {
+		w.Deadline = s.Deadline.UnixNano()
+	}
+
+	if s.Sample != (Sample{}) {
+		sample := s.Sample.finite()
+		w.Sample = (*sampleWire)(&sample)
+	}
+
+	// encoding/json writes the fields in order and sorts the map keys,
+	// therefore the encoding is canonical.
+	data, err := json.Marshal(w)
+	if err != nil {
+		// Only strings, numbers and booleans are encoded.
+		panic(err)
+	}
+
+	return append(append([]byte(nil), jobSpecPrefix...), data...)
+}
+
+// UnmarshalJobSpec decodes a JobSpec that was encoded with Marshal. It
+// returns ErrNotJobSpec if the data is not an encoded JobSpec.
+func UnmarshalJobSpec(data []byte) (JobSpec, error) {
+	if !bytes.HasPrefix(data, jobSpecPrefix) {
+		return JobSpec{}, ErrNotJobSpec
+	}
+
+	var w jobSpecWire
+	if err := json.Unmarshal(data[len(jobSpecPrefix):], &w); err != nil {
+		return JobSpec{}, err
+	}
+
+	s := JobSpec{
+		ID:          w.ID,
+		Route:       w.Route,
+		AlgName:     w.AlgName,
+		AlgVersion:  w.AlgVersion,
+		Params:      w.Params,
+		Caller:      w.Caller,
+		Tenant:      w.Tenant,
+		Script:      w.Script,
+		Traceparent: w.Traceparent,
+		Meta:        w.Meta,
+	}
+
+	if w.Deadline != 0 {
+		s.Deadline = time.Unix(0, w.Deadline)
+	}
+
+	if w.Sample != nil {
+		s.Sample = Sample(*w.Sample)
+	}
+
+	return s, nil
+}
+
+type jobSpecKey struct{}
+
+// WithJobSpec returns a copy of the context that holds the given JobSpec.
+func WithJobSpec(ctx context.Context, s JobSpec) context.Context {
+	return context.WithValue(ctx, jobSpecKey{}, s)
+}
+
+// JobSpecFromContext returns the JobSpec that was stored in the context via
+// WithJobSpec.
+func JobSpecFromContext(ctx context.Context) (JobSpec, bool) {
+	s, ok := ctx.Value(jobSpecKey{}).(JobSpec)
+	return s, ok
+}
+
+// ResolveJobSpec returns the JobSpec for a job. It prefers the JobSpec
+// stored in the context and falls back to decoding the meta information.
+// It reports false if neither holds a JobSpec.
+func ResolveJobSpec(ctx context.Context, meta []byte) (JobSpec, bool) {
+	if s, ok := JobSpecFromContext(ctx); ok {
+		return s, true
+	}
+
+	s, err := UnmarshalJobSpec(meta)
+	if err != nil {
+		return JobSpec{}, false
+	}
+
+	return s, true
+}
+
+// withJobSpecDeadline stores the JobSpec in the context and applies its
+// deadline.
+func withJobSpecDeadline(ctx context.Context, s JobSpec) (context.Context, context.CancelFunc) {
+	ctx = WithJobSpec(ctx, s)
+	if s.Deadline.IsZero() {
+		return context.WithCancel(ctx)
+	}
+
+	return context.WithDeadline(ctx, s.Deadline)
+}
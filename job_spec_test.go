@@ -0,0 +1,110 @@
+package mapreduce_test
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/poy/mapreduce"
+	"github.com/poy/onpar"
+	. "github.com/poy/onpar/expect"
+	. "github.com/poy/onpar/matchers"
+)
+
+type TJ struct {
+	*testing.T
+	spec mapreduce.JobSpec
+}
+
+func TestJobSpec(t *testing.T) {
+	t.Parallel()
+	o := onpar.New()
+	defer o.Run(t)
+
+	o.BeforeEach(func(t *testing.T) TJ {
+		return TJ{
+			T: t,
+			spec: mapreduce.JobSpec{
+				ID:         "some-id",
+				Route:      "some-route",
+				AlgName:    "some-alg",
+				AlgVersion: "some-version",
+				Params:     map[string]string{"a": "1", "b": "2"},
+				Deadline:   time.Unix(0, 99),
+				Caller:     "some-caller",
+				Tenant:     "some-tenant",
+				Sample:     mapreduce.Sample{FileFraction: 0.5, RecordFraction: 0.1, Seed: 7},
+				Meta:       []byte("some-meta"),
+
+				Traceparent: "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01",
+			},
+		}
+	})
+
+	o.Spec("it survives a round trip", func(t TJ) {
+		spec, err := mapreduce.UnmarshalJobSpec(t.spec.Marshal())
+		Expect(t, err == nil).To(BeTrue())
+		Expect(t, spec.ID).To(Equal("some-id"))
+		Expect(t, spec.Route).To(Equal("some-route"))
+		Expect(t, spec.AlgName).To(Equal("some-alg"))
+		Expect(t, spec.AlgVersion).To(Equal("some-version"))
+		Expect(t, spec.Params).To(Equal(map[string]string{"a": "1", "b": "2"}))
+		Expect(t, spec.Deadline.Equal(time.Unix(0, 99))).To(BeTrue())
+		Expect(t, spec.Caller).To(Equal("some-caller"))
+		Expect(t, spec.Tenant).To(Equal("some-tenant"))
+		Expect(t, spec.Traceparent).To(Equal(t.spec.Traceparent))
+		Expect(t, spec.Sample).To(Equal(t.spec.Sample))
+		Expect(t, spec.Meta).To(Equal([]byte("some-meta")))
+	})
+
+	o.Spec("it has a canonical encoding", func(t TJ) {
+		other := t.spec
+		other.Params = make(map[string]string)
+		other.Params["b"] = "2"
+		other.Params["a"] = "1"
+		other.Deadline = time.Unix(0, 99).In(time.FixedZone("other", 3600))
+
+		Expect(t, other.Marshal()).To(Equal(t.spec.Marshal()))
+	})
+
+	o.Spec("it encodes fractions that are not finite as 0", func(t TJ) {
+		t.spec.Sample = mapreduce.Sample{FileFraction: math.NaN(), RecordFraction: math.Inf(1), Seed: 7}
+
+		spec, err := mapreduce.UnmarshalJobSpec(t.spec.Marshal())
+		Expect(t, err == nil).To(BeTrue())
+		Expect(t, spec.Sample).To(Equal(mapreduce.Sample{Seed: 7}))
+	})
+
+	o.Spec("it returns ErrNotJobSpec for ad-hoc meta information", func(t TJ) {
+		_, err := mapreduce.UnmarshalJobSpec([]byte("some-meta"))
+		Expect(t, err).To(Equal(mapreduce.ErrNotJobSpec))
+	})
+
+	o.Spec("it stores the JobSpec in the context", func(t TJ) {
+		ctx := mapreduce.WithJobSpec(context.Background(), t.spec)
+		spec, ok := mapreduce.JobSpecFromContext(ctx)
+		Expect(t, ok).To(BeTrue())
+		Expect(t, spec.ID).To(Equal("some-id"))
+	})
+
+	o.Group("ResolveJobSpec", func() {
+		o.Spec("it prefers the context", func(t TJ) {
+			ctx := mapreduce.WithJobSpec(context.Background(), t.spec)
+			spec, ok := mapreduce.ResolveJobSpec(ctx, mapreduce.JobSpec{ID: "other"}.Marshal())
+			Expect(t, ok).To(BeTrue())
+			Expect(t, spec.ID).To(Equal("some-id"))
+		})
+
+		o.Spec("it falls back to the meta information", func(t TJ) {
+			spec, ok := mapreduce.ResolveJobSpec(context.Background(), t.spec.Marshal())
+			Expect(t, ok).To(BeTrue())
+			Expect(t, spec.ID).To(Equal("some-id"))
+		})
+
+		o.Spec("it reports false for ad-hoc meta information", func(t TJ) {
+			_, ok := mapreduce.ResolveJobSpec(context.Background(), []byte("some-meta"))
+			Expect(t, ok).To(BeFalse())
+		})
+	})
+}
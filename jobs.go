@@ -0,0 +1,325 @@
+package mapreduce
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// ErrUnknownJob is returned by Cancel when no job with the given ID is
+// running.
+var ErrUnknownJob = errors.New("unknown job")
+
+// ErrDuplicateJob is returned when a job is started with the ID of a running
+// job.
+var ErrDuplicateJob = errors.New("a job with the same ID is running")
+
+// ErrJobCanceled is returned when a job was canceled by its ID (see
+// MapReduce.Cancel and Executor.CancelJob).
+var ErrJobCanceled = errors.New("job was canceled")
+
+// JobStatus is the status of a job.
+type JobStatus int
+
+const (
+	// JobRunning jobs have not returned yet.
+	JobRunning JobStatus = iota
+
+	// JobSucceeded jobs returned a result.
+	JobSucceeded
+
+	// JobFailed jobs returned an error.
+	JobFailed
+
+	// JobCanceled jobs were canceled via Cancel.
+	JobCanceled
+)
+
+// String implements fmt.Stringer.
+func (s JobStatus) String() string {
+	switch s {
+	case JobRunning:
+		return "running"
+	case JobSucceeded:
+		return "succeeded"
+	case JobFailed:
+		return "failed"
+	case JobCanceled:
+		return "canceled"
+	default:
+		return fmt.Sprintf("JobStatus(%d)", int(s))
+	}
+}
+
+// JobInfo describes a running or recent job.
+type JobInfo struct {
+	ID      string
+	Route   string
+	AlgName string
+	Caller  string
+	Tenant  string
+	Status  JobStatus
+	Start   time.Time
+
+	// Summary is set once the job is finished.
+	Summary JobSummary
+}
+
+// WithJobHistory sets how many finished jobs are kept for Jobs and Job. It
+// defaults to 100.
+func WithJobHistory(n int) MapReduceOption {
+	return func(r *MapReduce) {
+		r.jobs.history = n
+	}
+}
+
+// Jobs returns the running and recent jobs ordered by their start.
+func (r MapReduce) Jobs() []JobInfo {
+	return r.jobs.list()
+}
+
+// Job returns the running or recent job with the given ID.
+func (r MapReduce) Job(jobID string) (JobInfo, bool) {
+	return r.jobs.get(jobID)
+}
+
+// Cancel cancels the running job with the given ID. Its Calculate returns
+// ErrJobCanceled. If the Network implements JobCanceler, the nodes that are
+// calculating files of the job are notified. It returns ErrUnknownJob if no
+// job with the ID is running.
+func (r MapReduce) Cancel(jobID string) error {
+	j, ok := r.jobs.cancel(jobID)
+	if !ok {
+		return ErrUnknownJob
+	}
+
+	nodes := j.activeNodes()
+	j.cancel()
+
+	canceler, ok := r.network.(JobCanceler)
+	if !ok {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	for _, id := range nodes {
+		if err := canceler.CancelJob(jobID, id, ctx); err != nil {
+			j.logger.Warn("failed to cancel job on node", "node", id, "err", err)
+		}
+	}
+
+	return nil
+}
+
+// newJobID returns a random job ID.
+func newJobID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+type jobRegistry struct {
+	history int
+
+	mu       sync.Mutex
+	running  map[string]*job
+	finished []JobInfo
+}
+
+func newJobRegistry() *jobRegistry {
+	return &jobRegistry{
+		history: 100,
+		running: make(map[string]*job),
+	}
+}
+
+// add registers the running job. The job takes the place of the pending
+// job that Submit registered for it (if any). It returns ErrDuplicateJob if
+// another job with the same ID is running.
+func (g *jobRegistry) add(j, pending *job) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if running, ok := g.running[j.spec.ID]; ok {
+		if pending == nil || running != pending {
+			return ErrDuplicateJob
+		}
+
+		if pending.isCanceled() {
+			j.markCanceled()
+		}
+	}
+	g.running[j.spec.ID] = j
+
+	return nil
+}
+
+// done moves the job to the history.
+func (g *jobRegistry) done(j *job, summary JobSummary) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.running[j.spec.ID] != j {
+		return
+	}
+	delete(g.running, j.spec.ID)
+
+	info := j.info()
+	info.Summary = summary
+	switch {
+	case info.Status == JobCanceled:
+	case summary.Err != nil:
+		info.Status = JobFailed
+	default:
+		info.Status = JobSucceeded
+	}
+
+	g.finished = append(g.finished, info)
+	if len(g.finished) > g.history {
+		g.finished = append([]JobInfo(nil), g.finished[len(g.finished)-g.history:]...)
+	}
+}
+
+// cancel marks the running job as canceled.
+func (g *jobRegistry) cancel(jobID string) (*job, bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	j, ok := g.running[jobID]
+	if !ok {
+		return nil, false
+	}
+	j.markCanceled()
+
+	return j, true
+}
+
+func (g *jobRegistry) list() []JobInfo {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	infos := append([]JobInfo(nil), g.finished...)
+	for _, j := range g.running {
+		infos = append(infos, j.info())
+	}
+
+	sort.SliceStable(infos, func(i, j int) bool {
+		return infos[i].Start.Before(infos[j].Start)
+	})
+
+	return infos
+}
+
+func (g *jobRegistry) get(jobID string) (JobInfo, bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if j, ok := g.running[jobID]; ok {
+		return j.info(), true
+	}
+
+	for i := len(g.finished) - 1; i >= 0; i-- {
+		if g.finished[i].ID == jobID {
+			return g.finished[i], true
+		}
+	}
+
+	return JobInfo{}, false
+}
+
+// canceledJobTTL is how long an Executor remembers a canceled job.
+const canceledJobTTL = 10 * time.Minute
+
+// executions tracks the running executions of an Executor by their job ID.
+type executions struct {
+	mu       sync.Mutex
+	next     int
+	running  map[string]map[int]context.CancelFunc
+	canceled map[string]time.Time
+}
+
+func newExecutions() *executions {
+	return &executions{
+		running:  make(map[string]map[int]context.CancelFunc),
+		canceled: make(map[string]time.Time),
+	}
+}
+
+// start registers an execution of the job. The returned context is canceled
+// when the job is canceled. It returns ErrJobCanceled if the job was
+// canceled within canceledJobTTL.
+func (e *executions) start(jobID string, ctx context.Context) (context.Context, func(), error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.isCanceledLocked(jobID) {
+		return nil, nil, ErrJobCanceled
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	e.next++
+	n := e.next
+	if e.running[jobID] == nil {
+		e.running[jobID] = make(map[int]context.CancelFunc)
+	}
+	e.running[jobID][n] = cancel
+
+	return ctx, func() {
+		cancel()
+
+		e.mu.Lock()
+		defer e.mu.Unlock()
+		delete(e.running[jobID], n)
+		if len(e.running[jobID]) == 0 {
+			delete(e.running, jobID)
+		}
+	}, nil
+}
+
+// cancel cancels every execution of the job and rejects later ones.
+func (e *executions) cancel(jobID string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	now := time.Now()
+	for id, t := range e.canceled {
+		if now.Sub(t) > canceledJobTTL {
+			delete(e.canceled, id)
+		}
+	}
+	e.canceled[jobID] = now
+
+	for _, cancel := range e.running[jobID] {
+		cancel()
+	}
+}
+
+func (e *executions) isCanceled(jobID string) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.isCanceledLocked(jobID)
+}
+
+// isCanceledLocked reports if the job was canceled within canceledJobTTL.
+// An older cancellation is forgotten so the job ID can be reused.
+func (e *executions) isCanceledLocked(jobID string) bool {
+	t, ok := e.canceled[jobID]
+	if !ok {
+		return false
+	}
+
+	if time.Since(t) > canceledJobTTL {
+		delete(e.canceled, jobID)
+		return false
+	}
+
+	return true
+}
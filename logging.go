@@ -0,0 +1,51 @@
+package mapreduce
+
+import (
+	"log/slog"
+	"strings"
+)
+
+// WithStructuredLogger sets the logger for structured logs. Log records carry
+// the job ID, algorithm, file, node and duration as attributes.
+func WithStructuredLogger(l *slog.Logger) MapReduceOption {
+	return func(r *MapReduce) {
+		r.logger = l
+	}
+}
+
+// WithExecutorLogger sets the logger for structured logs of the Executor.
+func WithExecutorLogger(l *slog.Logger) ExecutorOption {
+	return func(e *Executor) {
+		e.logger = l
+	}
+}
+
+// LogHandler returns a slog.Handler that writes every record (including debug
+// records) as a single line to the Log.
+func LogHandler(l Log) slog.Handler {
+	return slog.NewTextHandler(logWriter{l: l}, &slog.HandlerOptions{
+		Level: slog.LevelDebug,
+		ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+			// Log implementations (e.g., log.Logger) add their own timestamp.
+			if len(groups) == 0 && a.Key == slog.TimeKey {
+				return slog.Attr{}
+			}
+			return a
+		},
+	})
+}
+
+// logWriter writes each line to a Log.
+type logWriter struct {
+	l Log
+}
+
+func (w logWriter) Write(p []byte) (int, error) {
+	w.l.Printf("%s", strings.TrimSuffix(string(p), "\n"))
+	return len(p), nil
+}
+
+// discardLogger returns a logger that drops every record.
+func discardLogger() *slog.Logger {
+	return slog.New(slog.DiscardHandler)
+}
@@ -3,10 +3,11 @@
 package mapreduce
 
 import (
-	"io/ioutil"
-	"log"
-	"math/rand"
+	"bytes"
+	"log/slog"
+	"time"
 
+	"github.com/poy/mapreduce/routes"
 	"golang.org/x/net/context"
 )
 
@@ -14,6 +15,15 @@ import (
 type Algorithm struct {
 	Mapper
 	Reducer
+
+	// Version identifies the implementation of the Mapper and Reducer
+	// (e.g., a release version or a content hash). It is used to detect
+	// coordinators and nodes that run different implementations.
+	Version string
+
+	// Predicate optionally declares the records the algorithm needs. It is
+	// handed to a FilterFileSystem or applied by the Executor.
+	Predicate *Predicate
 }
 
 // MapReduceOption is used to configure a new MapReduce.
@@ -24,10 +34,27 @@ type Log interface {
 	Printf(format string, v ...interface{})
 }
 
-// WithLogger is used to set the given logger.
+// WithLogger is used to set the given logger. The structured logs are written as lines of key=value pairs
+// (see LogHandler).
 func WithLogger(l Log) MapReduceOption {
 	return func(r *MapReduce) {
-		r.log = l
+		r.logger = slog.New(LogHandler(l))
+	}
+}
+
+// WithMaxReduceIterations limits how often the Reducer is invoked for a single key. A value of 0 (the default)
+// only requires that every invocation shrinks the values.
+func WithMaxReduceIterations(n int) MapReduceOption {
+	return func(r *MapReduce) {
+		r.maxReduceIterations = n
+	}
+}
+
+// WithStrictRoutes makes Calculate validate routes against the standard grammar (see package routes) before the
+// FileSystem is asked for the files. An invalid route results in a *routes.SyntaxError.
+func WithStrictRoutes() MapReduceOption {
+	return func(r *MapReduce) {
+		r.strictRoutes = true
 	}
 }
 
@@ -38,16 +65,34 @@ type MapReduce struct {
 	fs         FileSystem
 	network    Network
 	algFetcher AlgorithmFetcher
-	log        Log
+	logger     *slog.Logger
+
+	maxReduceIterations int
+	jobTimeout          time.Duration
+	executeTimeout      time.Duration
+	speculateAfter      time.Duration
+	speculation         *speculationStats
+	breakers            *breakers
+	progress            ProgressObserver
+	metrics             Metrics
+	tracer              Tracer
+	jobs                *jobRegistry
+	slots               *nodeSlots
+	cache               *resultCache
+	strictRoutes        bool
 }
 
 // New returns a new MapReduce.
 func New(fs FileSystem, network Network, algFetcher AlgorithmFetcher, opts ...MapReduceOption) MapReduce {
 	r := MapReduce{
-		fs:         fs,
-		network:    network,
-		algFetcher: algFetcher,
-		log:        log.New(ioutil.Discard, "", 0),
+		fs:          fs,
+		network:     network,
+		algFetcher:  algFetcher,
+		logger:      discardLogger(),
+		speculation: &speculationStats{},
+		metrics:     nopMetrics{},
+		tracer:      nopTracer{},
+		jobs:        newJobRegistry(),
 	}
 
 	for _, o := range opts {
@@ -57,62 +102,204 @@ func New(fs FileSystem, network Network, algFetcher AlgorithmFetcher, opts ...Ma
 	return r
 }
 
+// CalculateJob runs the job described by the given JobSpec. The encoded
+// JobSpec is used as the meta information.
+func (r MapReduce) CalculateJob(spec JobSpec, ctx context.Context) (finalResult map[string][]byte, err error) {
+	return r.Calculate(spec.Route, spec.AlgName, WithJobSpec(ctx, spec), spec.Marshal())
+}
+
 // Calculate runs the given algorithm for the files returned from FileSystem for the given route and meta information.
 // It uses the Network to run the calculations across the remote nodes that report having the given data.
+//
+// The JobSpec of the job is stored in the context that is handed to the FileSystem and Network (see
+// JobSpecFromContext). If the context or meta information do not hold a JobSpec, one is created from the
+// route and algorithm name. The nodes get the encoded JobSpec as meta information; ad-hoc meta information is
+// wrapped into its Meta. The JobSpec's AlgVersion is set to the version of the local algorithm so nodes
+// can reject a different implementation. A panic in the Reducer is returned as a *PanicError and a Reducer
+// that does not converge results in a *NonConvergingError.
+//
+// The deadline of the job (see WithJobTimeout) and of each Network.Execute (see WithExecuteTimeout) is
+// applied to the context and the JobSpec's Deadline. A *TimeoutError is returned when a deadline is exceeded.
+//
+// The job is identified by the JobSpec's ID. A random ID is assigned if it is empty. While the job is
+// running, it is listed by Jobs and can be canceled by its ID via Cancel. Files whose result is cached (see
+// WithCache) are not executed.
+//
+// If the JobSpec holds a Sample, only a sample of the files and records is processed. The results are
+// scaled to the whole data if the Reducer implements Estimator (see CalculateEstimate).
 func (r MapReduce) Calculate(route, algName string, ctx context.Context, meta []byte) (finalResult map[string][]byte, err error) {
-	files, err := r.fs.Files(route, ctx, meta)
+	est, err := r.calculate(route, algName, ctx, meta)
 	if err != nil {
 		return nil, err
 	}
 
-	errs := make(chan error, len(files))
-	results := make(chan map[string][]byte, len(files))
+	return est.Result, nil
+}
+
+func (r MapReduce) calculate(route, algName string, ctx context.Context, meta []byte) (est Estimate, err error) {
+	ctx, span := startSpan(r.tracer, ctx, CalculateSpan,
+		Label{Name: "route", Value: route},
+		Label{Name: "alg", Value: algName},
+	)
+	defer func() { span.End(err) }()
+
+	if r.strictRoutes {
+		if _, err := routes.Parse(route); err != nil {
+			return Estimate{}, err
+		}
+	}
+
+	spec, _ := ResolveJobSpec(ctx, meta)
+	if len(meta) > 0 && !bytes.HasPrefix(meta, jobSpecPrefix) {
+		spec.Meta = meta
+	}
+
+	if spec.Route == "" {
+		spec.Route = route
+	}
+
+	if spec.AlgName == "" {
+		spec.AlgName = algName
+	}
+
+	if spec.ID == "" {
+		spec.ID = newJobID()
+	}
+	spec.Sample = spec.Sample.seeded()
+	span.SetAttributes(Label{Name: "job", Value: spec.ID})
+
+	reducer, err := r.algFetcher.Alg(algName, meta)
+	if err != nil {
+		return Estimate{}, err
+	}
+
+	if err := checkVersion(algName, spec.AlgVersion, reducer); err != nil {
+		return Estimate{}, err
+	}
+	spec.AlgVersion = reducer.Version
+
+	// The deadline of the job is stored in the JobSpec so it reaches the nodes.
+	if d, ok := ctx.Deadline(); ok {
+		spec.Deadline = earliest(spec.Deadline, d)
+	}
+	spec.Deadline = withTimeout(spec.Deadline, r.jobTimeout)
+
+	ctx, cancel := withJobSpecDeadline(ctx, spec)
+	defer cancel()
+
+	j := newJob(r, algName, spec, cancel)
+	if err := r.jobs.add(j, pendingJob(ctx)); err != nil {
+		return Estimate{}, err
+	}
+
+	defer func() {
+		// Wait for outstanding Network.Executes so JobFinished is the last event.
+		cancel()
+		j.wg.Wait()
+		if err != nil && j.isCanceled() {
+			est, err = Estimate{}, ErrJobCanceled
+		}
+		r.jobs.done(j, j.finish(len(est.Result), err))
+	}()
+
+	filesCtx, filesSpan := startSpan(r.tracer, ctx, FilesSpan)
+	files, err := r.fs.Files(route, filesCtx, meta)
+	filesSpan.End(err)
+	if err != nil {
+		return Estimate{}, err
+	}
+	est.Files = len(files)
+	files, strata := sampleFiles(files, spec.Sample)
+	est.SampledFiles = len(files)
+	est.Seed = spec.Sample.Seed
+	estimator := newEstimator(reducer.Reducer, algName, spec.Sample, strata)
+	j.scheduled(len(files))
+
+	results := make(chan fileResult, len(files))
+	outstanding := make(map[string]string)
 
 	for fileName, ids := range files {
 		// TODO: Balance load across nodes
-		id := ids[rand.Intn(len(ids))]
-		r.log.Printf("Start calculation for file %s on %s with algorithm %s", fileName, id, algName)
-		go func(fileName, id string) {
-			result, err := r.network.Execute(fileName, algName, id, ctx, meta)
-			if err != nil {
-				errs <- err
-				return
+		id := r.pickNode(ids, "")
+		outstanding[fileName] = id
+		j.wg.Add(1)
+		go func(fileName, id string, ids []string) {
+			defer j.wg.Done()
+
+			// The version of the file is looked up concurrently as the
+			// FileSystem might be remote.
+			key, cacheable := r.cache.key(r.fs, fileName, algName, reducer.Version, ctx, meta)
+			if cacheable {
+				if result, ok := r.cache.get(key); ok {
+					r.metrics.Add(MetricCacheHits, 1, j.labels()...)
+					j.emit(ProgressEvent{Type: FileCached, File: fileName, Keys: len(result)})
+					results <- fileResult{file: fileName, result: result}
+					return
+				}
+				r.metrics.Add(MetricCacheMisses, 1, j.labels()...)
 			}
 
-			results <- result
-		}(fileName, id)
+			j.logger.Debug("start calculation", "file", fileName, "node", id)
+			j.emit(ProgressEvent{Type: FileScheduled, File: fileName, NodeID: id})
+			result, err := j.runFile(fileName, id, ids, ctx)
+			if err == nil && cacheable {
+				r.cache.put(key, result)
+			}
+			results <- fileResult{file: fileName, result: result, err: err}
+		}(fileName, id, ids)
 	}
 
 	m := make(map[string][][]byte)
-	for i := 0; i < len(files); i++ {
+	for len(outstanding) > 0 {
 		select {
-		case err := <-errs:
-			return nil, err
-		case result := <-results:
+		case res := <-results:
+			if res.err != nil && j.expired(ctx) {
+				return Estimate{}, newTimeoutError(outstanding)
+			}
+
+			if res.err != nil {
+				return Estimate{}, res.err
+			}
+
+			delete(outstanding, res.file)
+			r.metrics.Add(MetricFilesProcessed, 1, j.labels()...)
+			r.metrics.Add(MetricBytesIn, float64(resultBytes(res.result)), j.labels()...)
+
+			result, err := estimator.add(res.file, res.result)
+			if err != nil {
+				return Estimate{}, err
+			}
+
 			for key, value := range result {
 				m[key] = append(m[key], value)
 			}
 		case <-ctx.Done():
-			break
+			if ctx.Err() == context.DeadlineExceeded {
+				return Estimate{}, newTimeoutError(outstanding)
+			}
+
+			return Estimate{}, ctx.Err()
 		}
 	}
 
-	finalResult = make(map[string][]byte)
-	reducer, err := r.algFetcher.Alg(algName, meta)
-	if err != nil {
-		return nil, err
-	}
+	_, reduceSpan := startSpan(r.tracer, ctx, ReduceSpan)
+	defer func() { reduceSpan.End(err) }()
 
+	est.Result = make(map[string][]byte)
 	for key, results := range m {
-		// TODO: Circuit break?
-		for len(results) > 1 {
-			results, err = reducer.Reduce(results)
-			if err != nil {
-				return nil, err
-			}
+		var iterations int
+		est.Result[key], iterations, err = reduceAll(reducer, results, algName, "", key, r.maxReduceIterations)
+		r.metrics.Observe(MetricReduceIterations, float64(iterations), j.labels()...)
+		if err != nil {
+			return Estimate{}, err
 		}
-		finalResult[key] = results[0]
+	}
+	r.metrics.Add(MetricBytesOut, float64(resultBytes(est.Result)), j.labels()...)
+
+	est.Bounds, err = estimator.bounds(est.Result)
+	if err != nil {
+		return Estimate{}, err
 	}
 
-	return finalResult, nil
+	return est, nil
 }
@@ -3,17 +3,27 @@
 package mapreduce_test
 
 import (
+	"bytes"
 	"context"
 	"flag"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
 	"os"
+	"runtime"
+	"strconv"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/poy/eachers/testhelpers"
 	"github.com/poy/mapreduce"
+	"github.com/poy/mapreduce/cache"
+	"github.com/poy/mapreduce/metrics"
+	"github.com/poy/mapreduce/routes"
+	"github.com/poy/mapreduce/tracing"
 	"github.com/poy/onpar"
 	. "github.com/poy/onpar/expect"
 	. "github.com/poy/onpar/matchers"
@@ -36,6 +46,12 @@ type TMR struct {
 	mockAlgorithm  *mockReducer
 	mockAlgFetcher *mockAlgorithmFetcher
 
+	network    mapreduce.Network
+	algFetcher mapreduce.AlgorithmFetcher
+	canceled   chan error
+	calls      chan string
+	healthy    *int32
+
 	mr mapreduce.MapReduce
 }
 
@@ -131,6 +147,183 @@ func TestMapReduce(t *testing.T) {
 					Expect(t, result["key-1"]).To(Equal([]byte("some-value-1")))
 				})
 
+				o.Spec("it passes the JobSpec to the Network", func(t TMR) {
+					t.mr.Calculate("some-file", "some-alg", context.Background(), []byte("some-meta"))
+
+					var ctx context.Context
+					Expect(t, t.mockNetwork.ExecuteInput.Ctx).To(Chain(Receive(), Fetch(&ctx)))
+					spec, ok := mapreduce.JobSpecFromContext(ctx)
+					Expect(t, ok).To(BeTrue())
+					Expect(t, spec.Route).To(Equal("some-file"))
+					Expect(t, spec.AlgName).To(Equal("some-alg"))
+
+					var meta []byte
+					Expect(t, t.mockNetwork.ExecuteInput.Meta).To(Chain(Receive(), Fetch(&meta)))
+					spec, err := mapreduce.UnmarshalJobSpec(meta)
+					Expect(t, err == nil).To(BeTrue())
+					Expect(t, spec.AlgName).To(Equal("some-alg"))
+					Expect(t, spec.Meta).To(Equal([]byte("some-meta")))
+				})
+
+				o.Spec("it uses the JobSpec from the meta information", func(t TMR) {
+					meta := mapreduce.JobSpec{ID: "some-id"}.Marshal()
+					t.mr.Calculate("some-file", "some-alg", context.Background(), meta)
+
+					var ctx context.Context
+					Expect(t, t.mockNetwork.ExecuteInput.Ctx).To(Chain(Receive(), Fetch(&ctx)))
+					spec, _ := mapreduce.JobSpecFromContext(ctx)
+					Expect(t, spec.ID).To(Equal("some-id"))
+				})
+
+				o.Spec("it assigns an ID to the job", func(t TMR) {
+					t.mr.Calculate("some-file", "some-alg", context.Background(), nil)
+
+					var ctx context.Context
+					Expect(t, t.mockNetwork.ExecuteInput.Ctx).To(Chain(Receive(), Fetch(&ctx)))
+					spec, _ := mapreduce.JobSpecFromContext(ctx)
+					Expect(t, spec.ID).To(Not(HaveLen(0)))
+
+					info, ok := t.mr.Job(spec.ID)
+					Expect(t, ok).To(BeTrue())
+					Expect(t, info.Status).To(Equal(mapreduce.JobSucceeded))
+					Expect(t, info.Route).To(Equal("some-file"))
+					Expect(t, info.Summary.Keys).To(Equal(2))
+					Expect(t, t.mr.Jobs()).To(HaveLen(1))
+				})
+
+				o.Spec("it submits a job", func(t TMR) {
+					h, err := t.mr.Submit("some-file", "some-alg", nil)
+					Expect(t, err == nil).To(BeTrue())
+					Expect(t, h.ID()).To(Not(HaveLen(0)))
+
+					result, err := h.Wait(context.Background())
+					Expect(t, err == nil).To(BeTrue())
+					Expect(t, result).To(HaveLen(2))
+					Expect(t, h.Status()).To(Equal(mapreduce.JobSucceeded))
+
+					result, err = h.Result()
+					Expect(t, err == nil).To(BeTrue())
+					Expect(t, result).To(HaveLen(2))
+				})
+
+				o.Spec("it sends the version of the algorithm", func(t TMR) {
+					mr := mapreduce.New(t.mockFileSystem, t.mockNetwork, mapreduce.AlgFetcherMap{
+						"some-alg": {Reducer: t.mockAlgorithm, Version: "v1"},
+					})
+					mr.Calculate("some-file", "some-alg", context.Background(), nil)
+
+					var ctx context.Context
+					Expect(t, t.mockNetwork.ExecuteInput.Ctx).To(Chain(Receive(), Fetch(&ctx)))
+					spec, _ := mapreduce.JobSpecFromContext(ctx)
+					Expect(t, spec.AlgVersion).To(Equal("v1"))
+				})
+
+				o.Spec("it rejects a different algorithm version", func(t TMR) {
+					meta := mapreduce.JobSpec{AlgVersion: "v2"}.Marshal()
+					_, err := t.mr.Calculate("some-file", "some-alg", context.Background(), meta)
+
+					_, ok := err.(*mapreduce.VersionMismatchError)
+					Expect(t, ok).To(BeTrue())
+					Expect(t, t.mockNetwork.ExecuteCalled).To(Always(HaveLen(0)))
+				})
+
+				o.Spec("it reports the progress", func(t TMR) {
+					events := make(chan mapreduce.ProgressEvent, 100)
+					mr := mapreduce.New(t.mockFileSystem, t.mockNetwork, t.mockAlgFetcher,
+						mapreduce.WithProgress(mapreduce.ProgressFunc(func(e mapreduce.ProgressEvent) {
+							events <- e
+						})),
+					)
+
+					meta := mapreduce.JobSpec{ID: "some-id"}.Marshal()
+					mr.Calculate("some-file", "some-alg", context.Background(), meta)
+					Expect(t, events).To(HaveLen(7))
+
+					count := make(map[mapreduce.ProgressEventType]int)
+					var last mapreduce.ProgressEvent
+					for len(events) > 0 {
+						last = <-events
+						count[last.Type]++
+						Expect(t, last.JobID).To(Equal("some-id"))
+
+						if last.Type == mapreduce.FileSucceeded {
+							Expect(t, last.NodeID).To(Not(HaveLen(0)))
+							Expect(t, last.Keys).To(Equal(1))
+						}
+					}
+
+					Expect(t, count[mapreduce.FileScheduled]).To(Equal(2))
+					Expect(t, count[mapreduce.FileStarted]).To(Equal(2))
+					Expect(t, count[mapreduce.FileSucceeded]).To(Equal(2))
+
+					Expect(t, last.Type).To(Equal(mapreduce.JobFinished))
+					Expect(t, last.Summary.Files).To(Equal(2))
+					Expect(t, last.Summary.Succeeded).To(Equal(2))
+					Expect(t, last.Summary.Keys).To(Equal(2))
+				})
+
+				o.Spec("it records metrics", func(t TMR) {
+					p := metrics.NewPrometheus()
+					mr := mapreduce.New(t.mockFileSystem, t.mockNetwork, t.mockAlgFetcher,
+						mapreduce.WithMetrics(p),
+					)
+					mr.Calculate("some-file", "some-alg", context.Background(), nil)
+
+					var buf bytes.Buffer
+					p.WriteTo(&buf)
+					Expect(t, buf.String()).To(ContainSubstring(`mapreduce_files_processed_total{alg="some-alg",component="coordinator"} 2`))
+					Expect(t, buf.String()).To(ContainSubstring(`mapreduce_bytes_in_total{alg="some-alg",component="coordinator"} 24`))
+					Expect(t, buf.String()).To(ContainSubstring(`mapreduce_bytes_out_total{alg="some-alg",component="coordinator"} 24`))
+					Expect(t, buf.String()).To(ContainSubstring(`mapreduce_execute_seconds_count{alg="some-alg",component="coordinator",node="id-`))
+					Expect(t, buf.String()).To(Not(ContainSubstring("mapreduce_errors_total")))
+				})
+
+				o.Spec("it traces the job", func(t TMR) {
+					r := tracing.NewRecorder()
+					mr := mapreduce.New(t.mockFileSystem, t.mockNetwork, t.mockAlgFetcher,
+						mapreduce.WithTracer(r),
+					)
+					mr.Calculate("some-file", "some-alg", context.Background(), nil)
+
+					calc := r.Find(mapreduce.CalculateSpan)
+					Expect(t, calc).To(HaveLen(1))
+					Expect(t, calc[0].Parent.IsValid()).To(BeFalse())
+
+					Expect(t, r.Find(mapreduce.FilesSpan)).To(HaveLen(1))
+					Expect(t, r.Find(mapreduce.FilesSpan)[0].Parent).To(Equal(calc[0].SpanContext))
+					Expect(t, r.Find(mapreduce.ReduceSpan)).To(HaveLen(1))
+
+					executes := r.Find(mapreduce.NetworkExecuteSpan)
+					Expect(t, executes).To(HaveLen(2))
+					for _, s := range executes {
+						Expect(t, s.Parent).To(Equal(calc[0].SpanContext))
+					}
+
+					var ctx context.Context
+					Expect(t, t.mockNetwork.ExecuteInput.Ctx).To(Chain(Receive(), Fetch(&ctx)))
+					spec, _ := mapreduce.JobSpecFromContext(ctx)
+					Expect(t, spec.Traceparent).To(Or(
+						Equal(executes[0].Traceparent()),
+						Equal(executes[1].Traceparent()),
+					))
+				})
+
+				o.Spec("it writes structured logs to the Log", func(t TMR) {
+					l := &spyLog{}
+					mr := mapreduce.New(t.mockFileSystem, t.mockNetwork, t.mockAlgFetcher,
+						mapreduce.WithLogger(l),
+					)
+
+					meta := mapreduce.JobSpec{ID: "some-id"}.Marshal()
+					mr.Calculate("some-file", "some-alg", context.Background(), meta)
+
+					lines := l.Lines()
+					Expect(t, lines).To(Not(HaveLen(0)))
+					Expect(t, lines[0]).To(ContainSubstring("job=some-id"))
+					Expect(t, lines[0]).To(ContainSubstring("alg=some-alg"))
+					Expect(t, lines[0]).To(ContainSubstring("file=some-name-"))
+				})
+
 				o.Spec("it does not need the reducer", func(t TMR) {
 					t.mr.Calculate("some-file", "some-alg", context.Background(), nil)
 
@@ -148,25 +341,24 @@ func TestMapReduce(t *testing.T) {
 						}
 					}()
 
-					go func() {
-						results := [][]byte{
-							[]byte("a"),
-							[]byte("b"),
-							[]byte("c"),
-							[]byte("d"),
-							[]byte("e"),
-						}
-						for {
-							t.mockAlgorithm.ReduceOutput.Reduced <- results
-							if len(results) == 0 {
-								return
-							}
-							results = results[1:]
-						}
-					}()
+					testhelpers.AlwaysReturn(t.mockAlgorithm.ReduceOutput.Reduced, [][]byte{[]byte("a")})
 					return t
 				})
 
+				o.Spec("it returns a PanicError when the reducer panics", func(t TMR) {
+					mr := mapreduce.New(t.mockFileSystem, t.mockNetwork, mapreduce.AlgFetcherMap{
+						"some-alg": {Reducer: mapreduce.ReduceFunc(func([][]byte) ([][]byte, error) {
+							panic("some-panic")
+						})},
+					})
+
+					_, err := mr.Calculate("some-file", "some-alg", context.Background(), nil)
+					perr, ok := err.(*mapreduce.PanicError)
+					Expect(t, ok).To(BeTrue())
+					Expect(t, perr.AlgName).To(Equal("some-alg"))
+					Expect(t, perr.Key).To(Equal("same-key"))
+				})
+
 				o.Group("when the reducer does not return an error", func() {
 					o.BeforeEach(func(t TMR) TMR {
 						close(t.mockAlgorithm.ReduceOutput.Err)
@@ -175,7 +367,7 @@ func TestMapReduce(t *testing.T) {
 
 					o.Spec("it returns the combined results", func(t TMR) {
 						result, _ := t.mr.Calculate("some-file", "some-alg", context.Background(), nil)
-						_ = result
+						Expect(t, result).To(Equal(map[string][]byte{"same-key": []byte("a")}))
 					})
 
 					o.Spec("it combines the results with the reducer", func(t TMR) {
@@ -187,12 +379,53 @@ func TestMapReduce(t *testing.T) {
 					})
 
 					o.Spec("it combines until there is a single result for the key", func(t TMR) {
-						t.mr.Calculate("some-file", "some-alg", context.Background(), nil)
+						fs := routeFileSystem{"some-file": {"a": {"node"}, "b": {"node"}, "c": {"node"}}}
+						network := funcNetwork(func(file, algName, nodeID string, ctx context.Context, meta []byte) (map[string][]byte, error) {
+							return map[string][]byte{"same-key": []byte(file)}, nil
+						})
+						var calls int32
+						mr := mapreduce.New(fs, network, mapreduce.AlgFetcherMap{
+							"some-alg": {Reducer: mapreduce.ReduceFunc(func(v [][]byte) ([][]byte, error) {
+								atomic.AddInt32(&calls, 1)
+								return v[1:], nil
+							})},
+						})
 
-						Expect(t, t.mockAlgorithm.ReduceCalled).To(HaveLen(5))
+						result, err := mr.Calculate("some-file", "some-alg", context.Background(), nil)
+						Expect(t, err).To(BeNil())
+						Expect(t, result).To(HaveLen(1))
+						Expect(t, atomic.LoadInt32(&calls)).To(Equal(int32(2)))
 					})
 				})
 
+				o.Spec("it returns a NonConvergingError when the reducer does not shrink the results", func(t TMR) {
+					mr := mapreduce.New(t.mockFileSystem, t.mockNetwork, mapreduce.AlgFetcherMap{
+						"some-alg": {Reducer: mapreduce.ReduceFunc(func(v [][]byte) ([][]byte, error) {
+							return v, nil
+						})},
+					})
+
+					_, err := mr.Calculate("some-file", "some-alg", context.Background(), nil)
+					nerr, ok := err.(*mapreduce.NonConvergingError)
+					Expect(t, ok).To(BeTrue())
+					Expect(t, nerr.Key).To(Equal("same-key"))
+					Expect(t, nerr.Input).To(Equal(2))
+					Expect(t, nerr.Output).To(Equal(2))
+				})
+
+				o.Spec("it returns nil for a key when the reducer returns no values", func(t TMR) {
+					mr := mapreduce.New(t.mockFileSystem, t.mockNetwork, mapreduce.AlgFetcherMap{
+						"some-alg": {Reducer: mapreduce.ReduceFunc(func(v [][]byte) ([][]byte, error) {
+							return nil, nil
+						})},
+					})
+
+					result, err := mr.Calculate("some-file", "some-alg", context.Background(), nil)
+					Expect(t, err == nil).To(BeTrue())
+					Expect(t, result).To(HaveLen(1))
+					Expect(t, result["same-key"]).To(HaveLen(0))
+				})
+
 				o.Group("when the reducer returns an error", func() {
 					o.BeforeEach(func(t TMR) TMR {
 						t.mockAlgorithm.ReduceOutput.Err <- fmt.Errorf("some-error")
@@ -207,6 +440,385 @@ func TestMapReduce(t *testing.T) {
 			})
 		})
 
+		o.Group("when the Network does not respond", func() {
+			o.Spec("it returns a TimeoutError for the job", func(t TMR) {
+				mr := mapreduce.New(t.mockFileSystem, t.mockNetwork, t.mockAlgFetcher,
+					mapreduce.WithJobTimeout(50*time.Millisecond),
+				)
+
+				_, err := mr.Calculate("some-file", "some-alg", context.Background(), nil)
+				terr, ok := err.(*mapreduce.TimeoutError)
+				Expect(t, ok).To(BeTrue())
+				Expect(t, terr.Outstanding).To(HaveLen(2))
+				Expect(t, terr.Outstanding["some-name-a"]).To(Or(Equal("id-a"), Equal("id-b")))
+			})
+
+			o.Spec("it returns a TimeoutError for the file", func(t TMR) {
+				mr := mapreduce.New(t.mockFileSystem, t.mockNetwork, t.mockAlgFetcher,
+					mapreduce.WithJobTimeout(time.Minute),
+					mapreduce.WithExecuteTimeout(50*time.Millisecond),
+				)
+
+				_, err := mr.Calculate("some-file", "some-alg", context.Background(), nil)
+				terr, ok := err.(*mapreduce.TimeoutError)
+				Expect(t, ok).To(BeTrue())
+				Expect(t, terr.Outstanding).To(HaveLen(1))
+			})
+
+			o.Spec("it passes the deadline to the nodes", func(t TMR) {
+				mr := mapreduce.New(t.mockFileSystem, t.mockNetwork, t.mockAlgFetcher,
+					mapreduce.WithJobTimeout(time.Minute),
+					mapreduce.WithExecuteTimeout(50*time.Millisecond),
+				)
+				mr.Calculate("some-file", "some-alg", context.Background(), nil)
+
+				var ctx context.Context
+				Expect(t, t.mockNetwork.ExecuteInput.Ctx).To(Chain(Receive(), Fetch(&ctx)))
+				deadline, ok := ctx.Deadline()
+				Expect(t, ok).To(BeTrue())
+				Expect(t, time.Until(deadline) < time.Second).To(BeTrue())
+
+				spec, _ := mapreduce.JobSpecFromContext(ctx)
+				Expect(t, spec.Deadline.Equal(deadline)).To(BeTrue())
+			})
+
+			o.Spec("it returns the error of a canceled context", func(t TMR) {
+				ctx, cancel := context.WithCancel(context.Background())
+				go func() {
+					<-t.mockNetwork.ExecuteCalled
+					cancel()
+				}()
+
+				_, err := t.mr.Calculate("some-file", "some-alg", ctx, nil)
+				Expect(t, err).To(Equal(context.Canceled))
+			})
+
+			o.Spec("it cancels a job by its ID", func(t TMR) {
+				network := cancelingNetwork{Network: t.mockNetwork, canceled: make(chan string, 10)}
+				mr := mapreduce.New(t.mockFileSystem, network, t.mockAlgFetcher)
+				go func() {
+					<-t.mockNetwork.ExecuteCalled
+					<-t.mockNetwork.ExecuteCalled
+					info, _ := mr.Job("some-id")
+					Expect(t, info.Status).To(Equal(mapreduce.JobRunning))
+					Expect(t, mr.Cancel("some-id") == nil).To(BeTrue())
+				}()
+
+				meta := mapreduce.JobSpec{ID: "some-id"}.Marshal()
+				_, err := mr.Calculate("some-file", "some-alg", context.Background(), meta)
+				Expect(t, err).To(Equal(mapreduce.ErrJobCanceled))
+
+				info, ok := mr.Job("some-id")
+				Expect(t, ok).To(BeTrue())
+				Expect(t, info.Status).To(Equal(mapreduce.JobCanceled))
+
+				// Both files might be calculated by the same node.
+				Expect(t, len(network.canceled)).To(Or(Equal(1), Equal(2)))
+				Expect(t, <-network.canceled).To(StartWith("some-id/id-"))
+			})
+
+			o.Spec("it rejects a job ID that is already running", func(t TMR) {
+				mr := mapreduce.New(t.mockFileSystem, t.mockNetwork, mapreduce.AlgFetcherMap{
+					"some-alg": {Reducer: t.mockAlgorithm},
+				})
+				meta := mapreduce.JobSpec{ID: "some-id"}.Marshal()
+				go func() {
+					<-t.mockNetwork.ExecuteCalled
+					_, err := mr.Calculate("some-file", "some-alg", context.Background(), meta)
+					Expect(t, err == nil).To(BeFalse())
+					mr.Cancel("some-id")
+				}()
+
+				_, err := mr.Calculate("some-file", "some-alg", context.Background(), meta)
+				Expect(t, err).To(Equal(mapreduce.ErrJobCanceled))
+			})
+
+			o.Spec("it cancels a submitted job", func(t TMR) {
+				h, err := t.mr.Submit("some-file", "some-alg", nil)
+				Expect(t, err == nil).To(BeTrue())
+				<-t.mockNetwork.ExecuteCalled
+
+				Expect(t, h.Status()).To(Equal(mapreduce.JobRunning))
+				_, err = h.Result()
+				Expect(t, err).To(Equal(mapreduce.ErrJobRunning))
+
+				ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+				defer cancel()
+				_, err = h.Wait(ctx)
+				Expect(t, err).To(Equal(context.DeadlineExceeded))
+
+				Expect(t, h.Cancel() == nil).To(BeTrue())
+				_, err = h.Wait(context.Background())
+				Expect(t, err).To(Equal(mapreduce.ErrJobCanceled))
+				Expect(t, h.Status()).To(Equal(mapreduce.JobCanceled))
+			})
+
+			o.Spec("it rejects a submitted job ID that is already running", func(t TMR) {
+				meta := mapreduce.JobSpec{ID: "some-id"}.Marshal()
+				h, err := t.mr.Submit("some-file", "some-alg", meta)
+				Expect(t, err == nil).To(BeTrue())
+
+				_, err = t.mr.Submit("some-file", "some-alg", meta)
+				Expect(t, err).To(Equal(mapreduce.ErrDuplicateJob))
+
+				Expect(t, h.Cancel() == nil).To(BeTrue())
+				_, err = h.Wait(context.Background())
+				Expect(t, err).To(Equal(mapreduce.ErrJobCanceled))
+
+				info, ok := t.mr.Job("some-id")
+				Expect(t, ok).To(BeTrue())
+				Expect(t, info.Status).To(Equal(mapreduce.JobCanceled))
+			})
+
+			o.Spec("it returns an error for an unknown job ID", func(t TMR) {
+				Expect(t, t.mr.Cancel("unknown")).To(Equal(mapreduce.ErrUnknownJob))
+			})
+		})
+
+		o.Group("when a node is slow", func() {
+			o.BeforeEach(func(t TMR) TMR {
+				var mu sync.Mutex
+				calls := make(map[string]int)
+				t.network = funcNetwork(func(file, algName, nodeID string, ctx context.Context, meta []byte) (map[string][]byte, error) {
+					mu.Lock()
+					calls[file]++
+					first := calls[file] == 1
+					mu.Unlock()
+
+					if first {
+						<-ctx.Done()
+						t.canceled <- ctx.Err()
+						return nil, ctx.Err()
+					}
+
+					return map[string][]byte{file: []byte(nodeID)}, nil
+				})
+				t.canceled = make(chan error, 100)
+				return t
+			})
+
+			o.Spec("it speculatively executes the file on another replica", func(t TMR) {
+				mr := mapreduce.New(t.mockFileSystem, t.network, t.mockAlgFetcher,
+					mapreduce.WithSpeculation(10*time.Millisecond),
+				)
+
+				result, err := mr.Calculate("some-file", "some-alg", context.Background(), nil)
+				Expect(t, err == nil).To(BeTrue())
+				Expect(t, result).To(HaveLen(2))
+
+				Expect(t, mr.SpeculationStats()).To(Equal(mapreduce.SpeculationStats{
+					Launched: 2,
+					Won:      2,
+				}))
+			})
+
+			o.Spec("it reports the speculative execution as retry", func(t TMR) {
+				var mu sync.Mutex
+				var summary mapreduce.JobSummary
+				mr := mapreduce.New(t.mockFileSystem, t.network, t.mockAlgFetcher,
+					mapreduce.WithSpeculation(10*time.Millisecond),
+					mapreduce.WithProgress(mapreduce.ProgressFunc(func(e mapreduce.ProgressEvent) {
+						if e.Type == mapreduce.JobFinished {
+							mu.Lock()
+							summary = e.Summary
+							mu.Unlock()
+						}
+					})),
+				)
+				mr.Calculate("some-file", "some-alg", context.Background(), nil)
+
+				mu.Lock()
+				defer mu.Unlock()
+				Expect(t, summary.Retried).To(Equal(2))
+				Expect(t, summary.Succeeded).To(Equal(2))
+			})
+
+			o.Spec("it cancels the slow execution", func(t TMR) {
+				mr := mapreduce.New(t.mockFileSystem, t.network, t.mockAlgFetcher,
+					mapreduce.WithSpeculation(10*time.Millisecond),
+				)
+				mr.Calculate("some-file", "some-alg", context.Background(), nil)
+
+				Expect(t, t.canceled).To(ViaPolling(HaveLen(2)))
+			})
+
+			o.Spec("it does not speculate by default", func(t TMR) {
+				mr := mapreduce.New(t.mockFileSystem, t.network, t.mockAlgFetcher,
+					mapreduce.WithJobTimeout(50*time.Millisecond),
+				)
+
+				_, err := mr.Calculate("some-file", "some-alg", context.Background(), nil)
+				Expect(t, err == nil).To(BeFalse())
+				Expect(t, mr.SpeculationStats().Launched).To(Equal(int64(0)))
+			})
+		})
+
+		o.Group("when a node keeps failing", func() {
+			o.BeforeEach(func(t TMR) TMR {
+				t.algFetcher = mapreduce.AlgFetcherMap{"some-alg": {Reducer: t.mockAlgorithm}}
+				t.calls = make(chan string, 1000)
+				var healthy int32
+				t.healthy = &healthy
+				t.network = funcNetwork(func(file, algName, nodeID string, ctx context.Context, meta []byte) (map[string][]byte, error) {
+					t.calls <- nodeID
+					if nodeID == "id-b" && atomic.LoadInt32(t.healthy) == 0 {
+						return nil, fmt.Errorf("some-error")
+					}
+
+					return map[string][]byte{file: []byte(nodeID)}, nil
+				})
+				return t
+			})
+
+			o.Spec("it routes around the node", func(t TMR) {
+				mr := mapreduce.New(t.mockFileSystem, t.network, t.algFetcher,
+					mapreduce.WithCircuitBreaker(1, time.Hour),
+				)
+
+				for i := 0; i < 20; i++ {
+					mr.Calculate("some-file", "some-alg", context.Background(), nil)
+				}
+
+				for i := 0; i < 10; i++ {
+					_, err := mr.Calculate("some-file", "some-alg", context.Background(), nil)
+					Expect(t, err == nil).To(BeTrue())
+				}
+
+				Expect(t, mr.BreakerState("id-b")).To(Equal(mapreduce.BreakerOpen))
+				Expect(t, mr.BreakerState("id-a")).To(Equal(mapreduce.BreakerClosed))
+			})
+
+			o.Spec("it probes the node after the cooldown", func(t TMR) {
+				mr := mapreduce.New(t.mockFileSystem, t.network, t.algFetcher,
+					mapreduce.WithCircuitBreaker(1, 10*time.Millisecond),
+				)
+
+				for mr.BreakerState("id-b") != mapreduce.BreakerOpen {
+					mr.Calculate("some-file", "some-alg", context.Background(), nil)
+				}
+
+				time.Sleep(20 * time.Millisecond)
+				Expect(t, mr.BreakerState("id-b")).To(Equal(mapreduce.BreakerHalfOpen))
+
+				atomic.StoreInt32(t.healthy, 1)
+				for mr.BreakerState("id-b") != mapreduce.BreakerClosed {
+					mr.Calculate("some-file", "some-alg", context.Background(), nil)
+				}
+			})
+
+			o.Spec("it probes the node again after a busy probe", func(t TMR) {
+				// state is 0 while id-b fails, 1 while it is busy and 2 once
+				// it is healthy.
+				var state int32
+				probed := make(chan struct{}, 100)
+				network := funcNetwork(func(file, algName, nodeID string, ctx context.Context, meta []byte) (map[string][]byte, error) {
+					if nodeID != "id-b" {
+						return map[string][]byte{file: []byte(nodeID)}, nil
+					}
+
+					switch atomic.LoadInt32(&state) {
+					case 0:
+						return nil, fmt.Errorf("some-error")
+					case 1:
+						probed <- struct{}{}
+						return nil, &mapreduce.BusyError{Running: 1}
+					default:
+						return map[string][]byte{file: []byte(nodeID)}, nil
+					}
+				})
+				fs := routeFileSystem{"some-file": {"some-name": {"id-a", "id-b"}}}
+				mr := mapreduce.New(fs, network, t.algFetcher,
+					mapreduce.WithCircuitBreaker(1, 10*time.Millisecond),
+				)
+
+				for mr.BreakerState("id-b") != mapreduce.BreakerOpen {
+					mr.Calculate("some-file", "some-alg", context.Background(), nil)
+				}
+
+				time.Sleep(20 * time.Millisecond)
+				atomic.StoreInt32(&state, 1)
+				for len(probed) == 0 {
+					mr.Calculate("some-file", "some-alg", context.Background(), nil)
+				}
+				Expect(t, mr.BreakerState("id-b")).To(Equal(mapreduce.BreakerHalfOpen))
+
+				atomic.StoreInt32(&state, 2)
+				for i := 0; i < 100 && mr.BreakerState("id-b") != mapreduce.BreakerClosed; i++ {
+					mr.Calculate("some-file", "some-alg", context.Background(), nil)
+				}
+				Expect(t, mr.BreakerState("id-b")).To(Equal(mapreduce.BreakerClosed))
+			})
+
+			o.Spec("it keeps using the node without a circuit breaker", func(t TMR) {
+				mr := mapreduce.New(t.mockFileSystem, t.network, t.algFetcher)
+
+				for i := 0; i < 20; i++ {
+					mr.Calculate("some-file", "some-alg", context.Background(), nil)
+				}
+
+				var failed int
+				for len(t.calls) > 0 {
+					if <-t.calls == "id-b" {
+						failed++
+					}
+				}
+				Expect(t, failed > 2).To(BeTrue())
+			})
+		})
+
+		o.Group("when a node is busy", func() {
+			o.BeforeEach(func(t TMR) TMR {
+				t.calls = make(chan string, 100)
+				t.network = funcNetwork(func(file, algName, nodeID string, ctx context.Context, meta []byte) (map[string][]byte, error) {
+					t.calls <- nodeID
+					if nodeID == "id-b" {
+						return nil, &mapreduce.BusyError{Running: 1}
+					}
+
+					return map[string][]byte{file: []byte(nodeID)}, nil
+				})
+				return t
+			})
+
+			o.Spec("it tries another replica", func(t TMR) {
+				events := make(chan mapreduce.ProgressEvent, 1000)
+				mr := mapreduce.New(t.mockFileSystem, t.network, mapreduce.AlgFetcherMap{"some-alg": {}},
+					mapreduce.WithProgress(mapreduce.ProgressFunc(func(e mapreduce.ProgressEvent) {
+						events <- e
+					})),
+				)
+
+				for i := 0; i < 10; i++ {
+					result, err := mr.Calculate("some-file", "some-alg", context.Background(), nil)
+					Expect(t, err == nil).To(BeTrue())
+					Expect(t, result["some-name-a"]).To(Equal([]byte("id-a")))
+					Expect(t, result["some-name-b"]).To(Equal([]byte("id-c")))
+				}
+
+				var busy, retried int
+				for len(t.calls) > 0 {
+					if <-t.calls == "id-b" {
+						busy++
+					}
+				}
+				for len(events) > 0 {
+					if (<-events).Type == mapreduce.FileRetried {
+						retried++
+					}
+				}
+				Expect(t, retried).To(Equal(busy))
+			})
+
+			o.Spec("it returns a BusyError when every replica is busy", func(t TMR) {
+				fs := routeFileSystem{"some-file": {"some-name": {"id-b"}}}
+				mr := mapreduce.New(fs, t.network, mapreduce.AlgFetcherMap{"some-alg": {}})
+
+				_, err := mr.Calculate("some-file", "some-alg", context.Background(), nil)
+				_, ok := err.(*mapreduce.BusyError)
+				Expect(t, ok).To(BeTrue())
+			})
+		})
+
 		o.Group("when the Network returns an error", func() {
 			o.BeforeEach(func(t TMR) TMR {
 				testhelpers.AlwaysReturn(t.mockNetwork.ExecuteOutput.Err, fmt.Errorf("some-error"))
@@ -222,6 +834,252 @@ func TestMapReduce(t *testing.T) {
 		})
 	})
 
+	o.Spec("it shares the node slots between jobs", func(t TMR) {
+		fs := routeFileSystem{
+			"route-a": {"a-1": {"node"}, "a-2": {"node"}, "a-3": {"node"}},
+			"route-b": {"b-1": {"node"}},
+		}
+		started := make(chan string, 10)
+		release := make(chan struct{})
+		network := funcNetwork(func(file, algName, nodeID string, ctx context.Context, meta []byte) (map[string][]byte, error) {
+			started <- file
+			<-release
+			return map[string][]byte{file: nil}, nil
+		})
+		mr := mapreduce.New(fs, network, mapreduce.AlgFetcherMap{"some-alg": {}},
+			mapreduce.WithNodeSlots(1),
+		)
+
+		a, _ := mr.Submit("route-a", "some-alg", nil)
+		Expect(t, <-started).To(StartWith("a-"))
+
+		for mr.WaitingForSlot("node") < 2 {
+			runtime.Gosched()
+		}
+
+		b, _ := mr.Submit("route-b", "some-alg", nil)
+		for mr.WaitingForSlot("node") < 3 {
+			runtime.Gosched()
+		}
+
+		release <- struct{}{}
+		Expect(t, <-started).To(Equal("b-1"))
+
+		for i := 0; i < 3; i++ {
+			release <- struct{}{}
+			if i < 2 {
+				Expect(t, <-started).To(StartWith("a-"))
+			}
+		}
+
+		result, err := a.Wait(context.Background())
+		Expect(t, err == nil).To(BeTrue())
+		Expect(t, result).To(HaveLen(3))
+
+		result, err = b.Wait(context.Background())
+		Expect(t, err == nil).To(BeTrue())
+		Expect(t, result).To(HaveLen(1))
+	})
+
+	o.Spec("it does not execute the files whose result is cached", func(t TMR) {
+		fs := versionedFileSystem{
+			FileSystem: routeFileSystem{"some-route": {"a": {"node"}, "b": {"node"}}},
+			versions:   map[string]string{"a": "1", "b": "1"},
+		}
+		executed := make(chan string, 10)
+		network := funcNetwork(func(file, algName, nodeID string, ctx context.Context, meta []byte) (map[string][]byte, error) {
+			executed <- file
+			return map[string][]byte{file: []byte(file)}, nil
+		})
+		events := make(chan mapreduce.ProgressEvent, 100)
+		mr := mapreduce.New(fs, network, mapreduce.AlgFetcherMap{"some-alg": {}},
+			mapreduce.WithCache(cache.NewLRU(10)),
+			mapreduce.WithProgress(mapreduce.ProgressFunc(func(e mapreduce.ProgressEvent) {
+				events <- e
+			})),
+		)
+
+		first, err := mr.Calculate("some-route", "some-alg", context.Background(), nil)
+		Expect(t, err == nil).To(BeTrue())
+		Expect(t, executed).To(HaveLen(2))
+
+		second, err := mr.Calculate("some-route", "some-alg", context.Background(), nil)
+		Expect(t, err == nil).To(BeTrue())
+		Expect(t, second).To(Equal(first))
+		Expect(t, executed).To(HaveLen(2))
+
+		var summary mapreduce.JobSummary
+		for len(events) > 0 {
+			if e := <-events; e.Type == mapreduce.JobFinished {
+				summary = e.Summary
+			}
+		}
+		Expect(t, summary.Cached).To(Equal(2))
+
+		fs.versions["b"] = "2"
+		_, err = mr.Calculate("some-route", "some-alg", context.Background(), nil)
+		Expect(t, err == nil).To(BeTrue())
+		Expect(t, executed).To(HaveLen(3))
+	})
+
+	o.Spec("it looks up the versions of the files concurrently", func(t TMR) {
+		fs := blockingVersionedFileSystem{
+			FileSystem: routeFileSystem{"some-route": {"a": {"node"}, "b": {"node"}, "c": {"node"}}},
+			started:    make(chan string, 3),
+			release:    make(chan struct{}),
+		}
+		network := funcNetwork(func(file, algName, nodeID string, ctx context.Context, meta []byte) (map[string][]byte, error) {
+			return map[string][]byte{file: nil}, nil
+		})
+		mr := mapreduce.New(fs, network, mapreduce.AlgFetcherMap{"some-alg": {}},
+			mapreduce.WithCache(cache.NewLRU(10)),
+		)
+
+		done := make(chan error, 1)
+		go func() {
+			_, err := mr.Calculate("some-route", "some-alg", context.Background(), nil)
+			done <- err
+		}()
+
+		for i := 0; i < 3; i++ {
+			Expect(t, fs.started).To(ViaPolling(Receive()))
+		}
+		close(fs.release)
+		Expect(t, <-done == nil).To(BeTrue())
+	})
+
+	o.Spec("it estimates the result from a sample of the files", func(t TMR) {
+		files := make(map[string][]string)
+		for i := 1; i <= 10; i++ {
+			files[strconv.Itoa(i)] = []string{"node"}
+		}
+		executed := make(chan string, 20)
+		network := funcNetwork(func(file, algName, nodeID string, ctx context.Context, meta []byte) (map[string][]byte, error) {
+			executed <- file
+			return map[string][]byte{"sum": []byte(file)}, nil
+		})
+		mr := mapreduce.New(routeFileSystem{"some-route": files}, network, mapreduce.AlgFetcherMap{"count": countAlg()})
+
+		spec := mapreduce.JobSpec{
+			Route:   "some-route",
+			AlgName: "count",
+			Sample:  mapreduce.Sample{FileFraction: 0.5, Seed: 1},
+		}
+		est, err := mr.CalculateEstimate(spec.Route, spec.AlgName, context.Background(), spec.Marshal())
+		Expect(t, err == nil).To(BeTrue())
+		Expect(t, est.Files).To(Equal(10))
+		Expect(t, est.SampledFiles).To(Equal(5))
+		Expect(t, executed).To(HaveLen(5))
+
+		var sum int
+		first := make(map[string]bool)
+		for len(executed) > 0 {
+			file := <-executed
+			first[file] = true
+			n, _ := strconv.Atoi(file)
+			sum += n
+		}
+		Expect(t, string(est.Result["sum"])).To(Equal(strconv.Itoa(2 * sum)))
+		Expect(t, est.Bounds["sum"] > 0).To(BeTrue())
+
+		// The same seed picks the same files.
+		_, err = mr.CalculateJob(spec, context.Background())
+		Expect(t, err == nil).To(BeTrue())
+		for len(executed) > 0 {
+			Expect(t, first[<-executed]).To(BeTrue())
+		}
+	})
+
+	o.Spec("it reports the random seed of a sample without one", func(t TMR) {
+		files := make(map[string][]string)
+		for i := 1; i <= 10; i++ {
+			files[strconv.Itoa(i)] = []string{"node"}
+		}
+		executed := make(chan string, 20)
+		network := funcNetwork(func(file, algName, nodeID string, ctx context.Context, meta []byte) (map[string][]byte, error) {
+			executed <- file
+			return map[string][]byte{"sum": []byte(file)}, nil
+		})
+		mr := mapreduce.New(routeFileSystem{"some-route": files}, network, mapreduce.AlgFetcherMap{"count": countAlg()})
+
+		spec := mapreduce.JobSpec{
+			Route:   "some-route",
+			AlgName: "count",
+			Sample:  mapreduce.Sample{FileFraction: 0.5},
+		}
+		est, err := mr.CalculateEstimate(spec.Route, spec.AlgName, context.Background(), spec.Marshal())
+		Expect(t, err == nil).To(BeTrue())
+		Expect(t, est.Seed).To(Not(Equal(int64(0))))
+
+		first := make(map[string]bool)
+		for len(executed) > 0 {
+			first[<-executed] = true
+		}
+
+		spec.Sample.Seed = est.Seed
+		_, err = mr.CalculateJob(spec, context.Background())
+		Expect(t, err == nil).To(BeTrue())
+		Expect(t, executed).To(HaveLen(5))
+		for len(executed) > 0 {
+			Expect(t, first[<-executed]).To(BeTrue())
+		}
+	})
+
+	o.Spec("it sends the JobSpec to nodes that only get the meta", func(t TMR) {
+		fs := routeFileSystem{"some-route": {"some-file": {"node"}}}
+		executor := mapreduce.NewExecutor(mapreduce.AlgFetcherMap{"some-alg": {Version: "v2"}}, fs)
+		network := funcNetwork(func(file, algName, nodeID string, ctx context.Context, meta []byte) (map[string][]byte, error) {
+			return executor.Execute(file, algName, context.Background(), meta)
+		})
+		mr := mapreduce.New(fs, network, mapreduce.AlgFetcherMap{"some-alg": {Version: "v1"}})
+
+		_, err := mr.CalculateJob(mapreduce.JobSpec{Route: "some-route", AlgName: "some-alg"}, context.Background())
+		Expect(t, err == nil).To(BeFalse())
+		Expect(t, err.Error()).To(ContainSubstring("v1"))
+	})
+
+	o.Spec("it checks the algorithm version on nodes for ad-hoc meta information", func(t TMR) {
+		fs := routeFileSystem{"some-route": {"some-file": {"node"}}}
+		executor := mapreduce.NewExecutor(mapreduce.AlgFetcherMap{"some-alg": {Version: "v2"}}, fs)
+		network := funcNetwork(func(file, algName, nodeID string, ctx context.Context, meta []byte) (map[string][]byte, error) {
+			return executor.Execute(file, algName, context.Background(), meta)
+		})
+		mr := mapreduce.New(fs, network, mapreduce.AlgFetcherMap{"some-alg": {Version: "v1"}})
+
+		_, err := mr.Calculate("some-route", "some-alg", context.Background(), []byte("some-meta"))
+		_, ok := err.(*mapreduce.VersionMismatchError)
+		Expect(t, ok).To(BeTrue())
+	})
+
+	o.Spec("it sends the JobSpec of the context to nodes when there is no meta", func(t TMR) {
+		fs := routeFileSystem{"some-route": {"some-file": {"node"}}}
+		metas := make(chan []byte, 1)
+		network := funcNetwork(func(file, algName, nodeID string, ctx context.Context, meta []byte) (map[string][]byte, error) {
+			metas <- meta
+			return nil, nil
+		})
+		mr := mapreduce.New(fs, network, mapreduce.AlgFetcherMap{"some-alg": {}})
+
+		ctx := mapreduce.WithJobSpec(context.Background(), mapreduce.JobSpec{ID: "some-id", Tenant: "some-tenant"})
+		_, err := mr.Calculate("some-route", "some-alg", ctx, nil)
+		Expect(t, err == nil).To(BeTrue())
+
+		spec, err := mapreduce.UnmarshalJobSpec(<-metas)
+		Expect(t, err == nil).To(BeTrue())
+		Expect(t, spec.ID).To(Equal("some-id"))
+		Expect(t, spec.Tenant).To(Equal("some-tenant"))
+		Expect(t, spec.Route).To(Equal("some-route"))
+	})
+
+	o.Spec("it rejects an invalid route before asking the FileSystem", func(t TMR) {
+		mr := mapreduce.New(t.mockFileSystem, t.mockNetwork, t.mockAlgFetcher, mapreduce.WithStrictRoutes())
+
+		_, err := mr.Calculate("logs/[2024-01-31..2024-01-01]", "some-alg", context.Background(), nil)
+		_, ok := err.(*routes.SyntaxError)
+		Expect(t, ok).To(BeTrue())
+		Expect(t, t.mockFileSystem.FilesCalled).To(Always(HaveLen(0)))
+	})
+
 	o.Group("when the FileSystem returns an error", func() {
 		o.BeforeEach(func(t TMR) TMR {
 			t.mockFileSystem.FilesOutput.Err <- fmt.Errorf("some-error")
@@ -271,3 +1129,73 @@ func toMap(a, b <-chan string, count int) (result map[string]string) {
 	return result
 
 }
+
+type funcNetwork func(file, algName, nodeID string, ctx context.Context, meta []byte) (map[string][]byte, error)
+
+func (f funcNetwork) Execute(file, algName, nodeID string, ctx context.Context, meta []byte) (map[string][]byte, error) {
+	return f(file, algName, nodeID, ctx, meta)
+}
+
+type routeFileSystem map[string]map[string][]string
+
+func (fs routeFileSystem) Files(route string, ctx context.Context, meta []byte) (map[string][]string, error) {
+	return fs[route], nil
+}
+
+// Reader returns an empty file.
+func (fs routeFileSystem) Reader(file string, ctx context.Context, meta []byte) (func() ([]byte, error), error) {
+	return func() ([]byte, error) {
+		return nil, io.EOF
+	}, nil
+}
+
+// versionedFileSystem reports the versions of the files.
+type versionedFileSystem struct {
+	mapreduce.FileSystem
+	versions map[string]string
+}
+
+func (fs versionedFileSystem) FileVersion(file string, ctx context.Context, meta []byte) (string, error) {
+	return fs.versions[file], nil
+}
+
+// blockingVersionedFileSystem reports each FileVersion and blocks it until
+// release is closed.
+type blockingVersionedFileSystem struct {
+	mapreduce.FileSystem
+	started chan string
+	release chan struct{}
+}
+
+func (fs blockingVersionedFileSystem) FileVersion(file string, ctx context.Context, meta []byte) (string, error) {
+	fs.started <- file
+	<-fs.release
+	return "1", nil
+}
+
+type cancelingNetwork struct {
+	mapreduce.Network
+	canceled chan string
+}
+
+func (n cancelingNetwork) CancelJob(jobID, nodeID string, ctx context.Context) error {
+	n.canceled <- jobID + "/" + nodeID
+	return nil
+}
+
+type spyLog struct {
+	mu    sync.Mutex
+	lines []string
+}
+
+func (l *spyLog) Printf(format string, v ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.lines = append(l.lines, fmt.Sprintf(format, v...))
+}
+
+func (l *spyLog) Lines() []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]string(nil), l.lines...)
+}
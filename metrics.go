@@ -0,0 +1,90 @@
+package mapreduce
+
+// Metrics records counters and histograms. It has to be safe for concurrent
+// use. The metrics subpackage provides implementations for Prometheus and
+// expvar.
+type Metrics interface {
+	// Add adds the value to the counter with the given name and labels.
+	Add(name string, value float64, labels ...Label)
+
+	// Observe records the value in the histogram with the given name and
+	// labels.
+	Observe(name string, value float64, labels ...Label)
+}
+
+// Label is a name/value pair that is attached to a measurement.
+type Label struct {
+	Name, Value string
+}
+
+// The names of the metrics. Every metric has a "component" label that is
+// either "coordinator" (MapReduce) or "executor" (Executor), and an "alg"
+// label. Metrics of the coordinator that relate to a Network.Execute have a
+// "node" label.
+const (
+	// MetricFilesProcessed counts the files that were calculated
+	// successfully.
+	MetricFilesProcessed = "mapreduce_files_processed_total"
+
+	// MetricRecordsMapped counts the records that were mapped to a key.
+	MetricRecordsMapped = "mapreduce_records_mapped_total"
+
+	// MetricRecordsFiltered counts the records that were mapped to an
+	// empty key.
+	MetricRecordsFiltered = "mapreduce_records_filtered_total"
+
+	// MetricReduceIterations observes the number of Reducer invocations
+	// for each key.
+	MetricReduceIterations = "mapreduce_reduce_iterations"
+
+	// MetricBytesIn counts the bytes of the records that were read
+	// (executor) or of the results that were received from the nodes
+	// (coordinator).
+	MetricBytesIn = "mapreduce_bytes_in_total"
+
+	// MetricBytesOut counts the bytes of the results.
+	MetricBytesOut = "mapreduce_bytes_out_total"
+
+	// MetricExecuteSeconds observes the duration of each Network.Execute
+	// (coordinator) or Executor.Execute (executor).
+	MetricExecuteSeconds = "mapreduce_execute_seconds"
+
+	// MetricErrors counts the failed Network.Executes (coordinator) and
+	// Executor.Executes (executor).
+	MetricErrors = "mapreduce_errors_total"
+)
+
+// Values of the "component" label.
+const (
+	CoordinatorComponent = "coordinator"
+	ExecutorComponent    = "executor"
+)
+
+// WithMetrics sets the Metrics of the MapReduce.
+func WithMetrics(m Metrics) MapReduceOption {
+	return func(r *MapReduce) {
+		r.metrics = m
+	}
+}
+
+// WithExecutorMetrics sets the Metrics of the Executor.
+func WithExecutorMetrics(m Metrics) ExecutorOption {
+	return func(e *Executor) {
+		e.metrics = m
+	}
+}
+
+// nopMetrics drops every measurement.
+type nopMetrics struct{}
+
+func (nopMetrics) Add(name string, value float64, labels ...Label)     {}
+func (nopMetrics) Observe(name string, value float64, labels ...Label) {}
+
+// resultBytes returns the size of the values of the result.
+func resultBytes(result map[string][]byte) (n int) {
+	for _, v := range result {
+		n += len(v)
+	}
+
+	return n
+}
@@ -0,0 +1,32 @@
+package metrics
+
+import (
+	"expvar"
+
+	"github.com/poy/mapreduce"
+)
+
+// Expvar implements mapreduce.Metrics. Each series of a counter is stored as
+// an *expvar.Float in the map. Its key is the name and labels of the series
+// (e.g., mapreduce_errors_total{alg="a",component="executor"}). Histograms
+// are stored as their count and sum (<name>_count and <name>_sum).
+type Expvar struct {
+	m *expvar.Map
+}
+
+// NewExpvar returns a new Expvar that stores the metrics in the map (e.g.,
+// expvar.NewMap("mapreduce")).
+func NewExpvar(m *expvar.Map) *Expvar {
+	return &Expvar{m: m}
+}
+
+// Add implements mapreduce.Metrics.
+func (e *Expvar) Add(name string, value float64, labels ...mapreduce.Label) {
+	e.m.AddFloat(series(name, labels), value)
+}
+
+// Observe implements mapreduce.Metrics.
+func (e *Expvar) Observe(name string, value float64, labels ...mapreduce.Label) {
+	e.m.AddFloat(series(name+"_count", labels), 1)
+	e.m.AddFloat(series(name+"_sum", labels), value)
+}
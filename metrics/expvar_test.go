@@ -0,0 +1,48 @@
+package metrics_test
+
+import (
+	"expvar"
+	"testing"
+
+	"github.com/poy/mapreduce"
+	"github.com/poy/mapreduce/metrics"
+	"github.com/poy/onpar"
+	. "github.com/poy/onpar/expect"
+	. "github.com/poy/onpar/matchers"
+)
+
+type TE struct {
+	*testing.T
+	m *expvar.Map
+	e *metrics.Expvar
+}
+
+func TestExpvar(t *testing.T) {
+	t.Parallel()
+	o := onpar.New()
+	defer o.Run(t)
+
+	o.BeforeEach(func(t *testing.T) TE {
+		m := new(expvar.Map).Init()
+		return TE{
+			T: t,
+			m: m,
+			e: metrics.NewExpvar(m),
+		}
+	})
+
+	o.Spec("it stores counters", func(t TE) {
+		t.e.Add("files_total", 1, mapreduce.Label{Name: "alg", Value: "a"})
+		t.e.Add("files_total", 2, mapreduce.Label{Name: "alg", Value: "a"})
+
+		Expect(t, t.m.Get(`files_total{alg="a"}`).String()).To(Equal("3"))
+	})
+
+	o.Spec("it stores the count and sum of histograms", func(t TE) {
+		t.e.Observe("latency", 0.5)
+		t.e.Observe("latency", 1.5)
+
+		Expect(t, t.m.Get("latency_count").String()).To(Equal("2"))
+		Expect(t, t.m.Get("latency_sum").String()).To(Equal("2"))
+	})
+}
@@ -0,0 +1,42 @@
+// metrics provides implementations of mapreduce.Metrics. Prometheus keeps
+// the metrics in memory and exposes them in the Prometheus text format.
+// Expvar publishes them via the expvar package.
+package metrics
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/poy/mapreduce"
+)
+
+// series returns the name of the metric with its labels in the Prometheus
+// format (e.g., name{a="b",c="d"}). The labels are sorted by name.
+func series(name string, labels []mapreduce.Label) string {
+	if len(labels) == 0 {
+		return name
+	}
+
+	sorted := append([]mapreduce.Label(nil), labels...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Name < sorted[j].Name
+	})
+
+	var b strings.Builder
+	b.WriteString(name)
+	b.WriteByte('{')
+	for i, l := range sorted {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(l.Name)
+		b.WriteString(`="`)
+		b.WriteString(labelEscaper.Replace(l.Value))
+		b.WriteByte('"')
+	}
+	b.WriteByte('}')
+
+	return b.String()
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
@@ -0,0 +1,202 @@
+package metrics
+
+import (
+	"bytes"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/poy/mapreduce"
+)
+
+// DefaultBuckets are the upper bounds of the buckets of histograms that are
+// not configured via WithBuckets. They suit durations in seconds.
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// Prometheus implements mapreduce.Metrics. It keeps the metrics in memory
+// and writes them in the Prometheus text exposition format. It implements
+// http.Handler to be scraped.
+//
+// A Prometheus has to be created with NewPrometheus().
+type Prometheus struct {
+	buckets map[string][]float64
+
+	mu         sync.Mutex
+	counters   map[string]map[string]float64
+	histograms map[string]map[string]*histogram
+}
+
+type histogram struct {
+	labels []mapreduce.Label
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+// PrometheusOption is used to configure a new Prometheus.
+type PrometheusOption func(*Prometheus)
+
+// WithBuckets sets the upper bounds of the buckets of the histogram with the
+// given name. The bounds have to be sorted.
+func WithBuckets(name string, buckets []float64) PrometheusOption {
+	return func(p *Prometheus) {
+		p.buckets[name] = buckets
+	}
+}
+
+// NewPrometheus returns a new Prometheus. The histogram of
+// mapreduce.MetricReduceIterations uses powers of two as buckets.
+func NewPrometheus(opts ...PrometheusOption) *Prometheus {
+	p := &Prometheus{
+		buckets: map[string][]float64{
+			mapreduce.MetricReduceIterations: {1, 2, 4, 8, 16, 32, 64},
+		},
+		counters:   make(map[string]map[string]float64),
+		histograms: make(map[string]map[string]*histogram),
+	}
+
+	for _, o := range opts {
+		o(p)
+	}
+
+	return p
+}
+
+// Add implements mapreduce.Metrics.
+func (p *Prometheus) Add(name string, value float64, labels ...mapreduce.Label) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	s, ok := p.counters[name]
+	if !ok {
+		s = make(map[string]float64)
+		p.counters[name] = s
+	}
+
+	s[series(name, labels)] += value
+}
+
+// Observe implements mapreduce.Metrics.
+func (p *Prometheus) Observe(name string, value float64, labels ...mapreduce.Label) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	s, ok := p.histograms[name]
+	if !ok {
+		s = make(map[string]*histogram)
+		p.histograms[name] = s
+	}
+
+	buckets := p.bucketsOf(name)
+	key := series(name, labels)
+	h, ok := s[key]
+	if !ok {
+		h = &histogram{
+			labels: append([]mapreduce.Label(nil), labels...),
+			counts: make([]uint64, len(buckets)),
+		}
+		s[key] = h
+	}
+
+	for i, b := range buckets {
+		if value <= b {
+			h.counts[i]++
+		}
+	}
+	h.count++
+	h.sum += value
+}
+
+func (p *Prometheus) bucketsOf(name string) []float64 {
+	if b, ok := p.buckets[name]; ok {
+		return b
+	}
+
+	return DefaultBuckets
+}
+
+// WriteTo writes every metric in the Prometheus text exposition format. The
+// metrics and their series are sorted by name.
+func (p *Prometheus) WriteTo(w io.Writer) (int64, error) {
+	// The metrics are rendered first so a slow writer does not block them.
+	var buf bytes.Buffer
+	p.render(&buf)
+
+	return buf.WriteTo(w)
+}
+
+// render writes a snapshot of every metric to the buffer.
+func (p *Prometheus) render(bw *bytes.Buffer) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var names []string
+	for name := range p.counters {
+		names = append(names, name)
+	}
+	for name := range p.histograms {
+		if _, ok := p.counters[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if s, ok := p.counters[name]; ok {
+			bw.WriteString("# TYPE " + name + " counter\n")
+			keys := make([]string, 0, len(s))
+			for key := range s {
+				keys = append(keys, key)
+			}
+			sort.Strings(keys)
+
+			for _, key := range keys {
+				bw.WriteString(key + " " + formatFloat(s[key]) + "\n")
+			}
+			continue
+		}
+
+		s := p.histograms[name]
+		buckets := p.bucketsOf(name)
+		bw.WriteString("# TYPE " + name + " histogram\n")
+		keys := make([]string, 0, len(s))
+		for key := range s {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			h := s[key]
+			for i, b := range buckets {
+				bw.WriteString(bucket(name, h.labels, formatFloat(b)) + " " + strconv.FormatUint(h.counts[i], 10) + "\n")
+			}
+			bw.WriteString(bucket(name, h.labels, "+Inf") + " " + strconv.FormatUint(h.count, 10) + "\n")
+			bw.WriteString(series(name+"_sum", h.labels) + " " + formatFloat(h.sum) + "\n")
+			bw.WriteString(series(name+"_count", h.labels) + " " + strconv.FormatUint(h.count, 10) + "\n")
+		}
+	}
+}
+
+// ServeHTTP implements http.Handler.
+func (p *Prometheus) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	p.WriteTo(w)
+}
+
+// bucket returns the series of a bucket of the histogram.
+func bucket(name string, labels []mapreduce.Label, le string) string {
+	l := make([]mapreduce.Label, 0, len(labels)+1)
+	l = append(l, labels...)
+	return series(name+"_bucket", append(l, mapreduce.Label{Name: "le", Value: le}))
+}
+
+func formatFloat(f float64) string {
+	if math.IsInf(f, 1) {
+		return "+Inf"
+	}
+
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
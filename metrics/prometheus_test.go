@@ -0,0 +1,107 @@
+package metrics_test
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/poy/mapreduce"
+	"github.com/poy/mapreduce/metrics"
+	"github.com/poy/onpar"
+	. "github.com/poy/onpar/expect"
+	. "github.com/poy/onpar/matchers"
+)
+
+type TP struct {
+	*testing.T
+	p *metrics.Prometheus
+}
+
+func TestPrometheus(t *testing.T) {
+	t.Parallel()
+	o := onpar.New()
+	defer o.Run(t)
+
+	o.BeforeEach(func(t *testing.T) TP {
+		return TP{
+			T: t,
+			p: metrics.NewPrometheus(metrics.WithBuckets("latency", []float64{1, 2})),
+		}
+	})
+
+	o.Spec("it writes counters", func(t TP) {
+		t.p.Add("files_total", 1, mapreduce.Label{Name: "b", Value: "x"}, mapreduce.Label{Name: "a", Value: "y"})
+		t.p.Add("files_total", 2, mapreduce.Label{Name: "a", Value: "y"}, mapreduce.Label{Name: "b", Value: "x"})
+		t.p.Add("files_total", 1)
+
+		Expect(t, write(t.p)).To(Equal(`# TYPE files_total counter
+files_total 1
+files_total{a="y",b="x"} 3
+`))
+	})
+
+	o.Spec("it writes histograms", func(t TP) {
+		t.p.Observe("latency", 0.5, mapreduce.Label{Name: "node", Value: "a"})
+		t.p.Observe("latency", 1.5, mapreduce.Label{Name: "node", Value: "a"})
+		t.p.Observe("latency", 3, mapreduce.Label{Name: "node", Value: "a"})
+
+		Expect(t, write(t.p)).To(Equal(`# TYPE latency histogram
+latency_bucket{le="1",node="a"} 1
+latency_bucket{le="2",node="a"} 2
+latency_bucket{le="+Inf",node="a"} 3
+latency_sum{node="a"} 5
+latency_count{node="a"} 3
+`))
+	})
+
+	o.Spec("it escapes label values", func(t TP) {
+		t.p.Add("c", 1, mapreduce.Label{Name: "a", Value: "x\"\\\ny"})
+		Expect(t, write(t.p)).To(ContainSubstring(`c{a="x\"\\\ny"} 1`))
+	})
+
+	o.Spec("it sorts the metrics by name", func(t TP) {
+		t.p.Observe("b", 1)
+		t.p.Add("a", 1)
+		t.p.Add("c", 1)
+
+		out := write(t.p)
+		Expect(t, strings.Index(out, "# TYPE a") < strings.Index(out, "# TYPE b")).To(BeTrue())
+		Expect(t, strings.Index(out, "# TYPE b") < strings.Index(out, "# TYPE c")).To(BeTrue())
+	})
+
+	o.Spec("it serves the metrics via HTTP", func(t TP) {
+		t.p.Add("a", 1)
+
+		rec := httptest.NewRecorder()
+		t.p.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+		Expect(t, rec.Header().Get("Content-Type")).To(ContainSubstring("text/plain"))
+		Expect(t, rec.Body.String()).To(Equal("# TYPE a counter\na 1\n"))
+	})
+
+	o.Spec("it does not hold the metrics while writing", func(t TP) {
+		t.p.Add("a", 1)
+
+		// The writer records a metric itself, which blocks if WriteTo
+		// holds the lock.
+		w := writerFunc(func(b []byte) (int, error) {
+			t.p.Add("b", 1)
+			return len(b), nil
+		})
+		n, err := t.p.WriteTo(w)
+		Expect(t, err == nil).To(BeTrue())
+		Expect(t, n).To(Equal(int64(len("# TYPE a counter\na 1\n"))))
+	})
+}
+
+type writerFunc func(b []byte) (int, error)
+
+func (f writerFunc) Write(b []byte) (int, error) {
+	return f(b)
+}
+
+func write(p *metrics.Prometheus) string {
+	var buf bytes.Buffer
+	p.WriteTo(&buf)
+	return buf.String()
+}
@@ -7,5 +7,16 @@ type Network interface {
 	// Execute is invoked to run calculations for a file (file) on a remote node (nodeID) with the given algorithm
 	// (algName). Any necessary information can be encoded into meta. The context (ctx) is used for lifecycle
 	// management.
+	//
+	// The meta holds the resolved JobSpec (e.g., with the ID, AlgVersion and Deadline). Ad-hoc meta information
+	// of the caller is wrapped into the JobSpec's Meta and unwrapped by the Executor.
 	Execute(file, algName, nodeID string, ctx context.Context, meta []byte) (result map[string][]byte, err error)
 }
+
+// JobCanceler is an optional extension of a Network. It is used by
+// MapReduce.Cancel to tell nodes that a job was canceled, even if the context
+// of their Network.Execute is not closed (see Executor.CancelJob).
+type JobCanceler interface {
+	// CancelJob cancels the work of the job (jobID) on the node (nodeID).
+	CancelJob(jobID, nodeID string, ctx context.Context) error
+}
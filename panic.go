@@ -0,0 +1,116 @@
+package mapreduce
+
+import (
+	"fmt"
+	"runtime/debug"
+)
+
+// PanicError is returned when a Mapper, Reducer (including an Estimator) or
+// Predicate panics.
+type PanicError struct {
+	AlgName string
+
+	// File is empty when the coordinator reduces the results of several
+	// files.
+	File string
+
+	// Key is empty when a Mapper panics.
+	Key string
+
+	// Value is the value that was passed to panic.
+	Value interface{}
+
+	// Stack is the stack trace of the panic. It is not part of Error.
+	Stack []byte
+}
+
+// Error implements error.
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("algorithm %s panicked (file=%q key=%q): %v", e.AlgName, e.File, e.Key, e.Value)
+}
+
+// safeMap invokes the Mapper and turns a panic into a *PanicError.
+func safeMap(m Mapper, value []byte, algName, file string) (key string, output []byte, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = &PanicError{
+				AlgName: algName,
+				File:    file,
+				Value:   r,
+				Stack:   debug.Stack(),
+			}
+		}
+	}()
+
+	return m.Map(value)
+}
+
+// safeMatch invokes the Match of a Predicate and turns a panic into a
+// *PanicError.
+func safeMatch(match func([]byte) bool, value []byte, algName, file string) (ok bool, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = &PanicError{
+				AlgName: algName,
+				File:    file,
+				Value:   r,
+				Stack:   debug.Stack(),
+			}
+		}
+	}()
+
+	return match(value), nil
+}
+
+// safeParseEstimate invokes ParseEstimate of the Estimator and turns a panic
+// into a *PanicError.
+func safeParseEstimate(e Estimator, value []byte, algName, file, key string) (v float64, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = &PanicError{
+				AlgName: algName,
+				File:    file,
+				Key:     key,
+				Value:   r,
+				Stack:   debug.Stack(),
+			}
+		}
+	}()
+
+	return e.ParseEstimate(value)
+}
+
+// safeFormatEstimate invokes FormatEstimate of the Estimator and turns a
+// panic into a *PanicError.
+func safeFormatEstimate(e Estimator, v float64, algName, file, key string) (value []byte, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = &PanicError{
+				AlgName: algName,
+				File:    file,
+				Key:     key,
+				Value:   r,
+				Stack:   debug.Stack(),
+			}
+		}
+	}()
+
+	return e.FormatEstimate(v), nil
+}
+
+// safeReduce invokes the Reducer and turns a panic into a *PanicError.
+func safeReduce(r Reducer, values [][]byte, algName, file, key string) (reduced [][]byte, err error) {
+	defer func() {
+		if v := recover(); v != nil {
+			err = &PanicError{
+				AlgName: algName,
+				File:    file,
+				Key:     key,
+				Value:   v,
+				Stack:   debug.Stack(),
+			}
+		}
+	}()
+
+	return r.Reduce(values)
+}
@@ -0,0 +1,96 @@
+package mapreduce
+
+import (
+	"fmt"
+	"strings"
+
+	"golang.org/x/net/context"
+)
+
+// Op is the comparison of a Condition.
+type Op string
+
+// The comparisons of a Condition.
+const (
+	Eq Op = "="
+	Ne Op = "!="
+	Lt Op = "<"
+	Le Op = "<="
+	Gt Op = ">"
+	Ge Op = ">="
+)
+
+// Condition compares a field of a record to a value. The FileSystem decides
+// how fields are stored and compared (e.g., columns or partitions).
+type Condition struct {
+	Field string
+	Op    Op
+	Value string
+}
+
+// String implements fmt.Stringer.
+func (c Condition) String() string {
+	return fmt.Sprintf("%s %s %q", c.Field, c.Op, c.Value)
+}
+
+// Predicate declares the records that an algorithm needs (see
+// Algorithm.Predicate). A FileSystem that implements FilterFileSystem can use
+// it to skip files, blocks or rows.
+type Predicate struct {
+	// Conditions have to hold for every record that is needed.
+	Conditions []Condition
+
+	// Match reports if the record satisfies the Conditions. The Executor
+	// uses it when the FileSystem does not filter the records exactly. If
+	// it is nil, the Mapper has to filter the records.
+	Match func(value []byte) bool
+}
+
+// String implements fmt.Stringer.
+func (p Predicate) String() string {
+	conditions := make([]string, 0, len(p.Conditions))
+	for _, c := range p.Conditions {
+		conditions = append(conditions, c.String())
+	}
+
+	return strings.Join(conditions, " AND ")
+}
+
+// FilterFileSystem is an optional extension of a FileSystem that filters the
+// records of a file by a Predicate.
+type FilterFileSystem interface {
+	FileSystem
+
+	// FilteredReader returns a reader for the records of the file that may
+	// satisfy the Predicate. Exact reports if every returned record
+	// satisfies the Predicate so the Executor does not check it again.
+	FilteredReader(file string, p Predicate, ctx context.Context, meta []byte) (reader func() (data []byte, err error), exact bool, err error)
+}
+
+// MetricRecordsPruned counts the records that the Executor dropped due to
+// the Predicate of the algorithm.
+const MetricRecordsPruned = "mapreduce_records_pruned_total"
+
+// reader returns the reader of the file. The Predicate of the algorithm is
+// handed to a FilterFileSystem. The returned function matches the records
+// that still have to be checked; it is nil if every record is needed.
+func (e *Executor) reader(fileName string, alg Algorithm, ctx context.Context, meta []byte) (func() ([]byte, error), func([]byte) bool, error) {
+	p := alg.Predicate
+	if p == nil {
+		reader, err := e.fs.Reader(fileName, ctx, meta)
+		return reader, nil, err
+	}
+
+	ffs, ok := e.fs.(FilterFileSystem)
+	if !ok {
+		reader, err := e.fs.Reader(fileName, ctx, meta)
+		return reader, p.Match, err
+	}
+
+	reader, exact, err := ffs.FilteredReader(fileName, *p, ctx, meta)
+	if err != nil || exact {
+		return reader, nil, err
+	}
+
+	return reader, p.Match, nil
+}
@@ -0,0 +1,187 @@
+package mapreduce
+
+import (
+	"fmt"
+	"time"
+)
+
+// ProgressEventType is the type of a ProgressEvent.
+type ProgressEventType int
+
+const (
+	// FileScheduled is emitted when a node is picked for a file.
+	FileScheduled ProgressEventType = iota
+
+	// FileStarted is emitted before each Network.Execute.
+	FileStarted
+
+	// FileSucceeded is emitted when a Network.Execute returns a result.
+	FileSucceeded
+
+	// FileFailed is emitted when a Network.Execute returns an error.
+	FileFailed
+
+	// FileRetried is emitted when a file is executed again on another
+	// node.
+	FileRetried
+
+	// JobFinished is emitted when Calculate returns. It is the last event
+	// of a job.
+	JobFinished
+
+	// FileCached is emitted when the result of a file is taken from the
+	// cache (see WithCache).
+	FileCached
+)
+
+// String implements fmt.Stringer.
+func (t ProgressEventType) String() string {
+	switch t {
+	case FileScheduled:
+		return "scheduled"
+	case FileStarted:
+		return "started"
+	case FileSucceeded:
+		return "succeeded"
+	case FileFailed:
+		return "failed"
+	case FileRetried:
+		return "retried"
+	case JobFinished:
+		return "finished"
+	case FileCached:
+		return "cached"
+	default:
+		return fmt.Sprintf("ProgressEventType(%d)", int(t))
+	}
+}
+
+// ProgressEvent reports the progress of a job.
+type ProgressEvent struct {
+	Type ProgressEventType
+
+	// JobID is the ID of the JobSpec.
+	JobID string
+
+	// File and NodeID are empty for JobFinished.
+	File   string
+	NodeID string
+
+	// Duration is the duration of the Network.Execute for FileSucceeded
+	// and FileFailed and of the whole job for JobFinished.
+	Duration time.Duration
+
+	// Keys is the number of keys in the result.
+	Keys int
+
+	// Err is set for FileFailed and failed jobs.
+	Err error
+
+	// Speculative is set for speculative Network.Executes (see
+	// WithSpeculation).
+	Speculative bool
+
+	// Summary is set for JobFinished.
+	Summary JobSummary
+}
+
+// JobSummary summarizes a finished job.
+type JobSummary struct {
+	// Files is the number of files returned by the FileSystem.
+	Files int
+
+	// Succeeded and Failed count the Network.Executes. Canceled
+	// Network.Executes (e.g., speculative executions that lost) count as
+	// failed.
+	Succeeded int
+	Failed    int
+
+	// Retried counts the files that were executed again.
+	Retried int
+
+	// Cached counts the files whose result was taken from the cache.
+	Cached int
+
+	// Keys is the number of keys in the final result.
+	Keys int
+
+	Duration time.Duration
+	Err      error
+}
+
+// ProgressObserver is notified about the progress of every job. It has to
+// be safe for concurrent use and should not block.
+type ProgressObserver interface {
+	Progress(e ProgressEvent)
+}
+
+// ProgressFunc wraps a function into a ProgressObserver.
+type ProgressFunc func(e ProgressEvent)
+
+// Progress implements the ProgressObserver interface.
+func (f ProgressFunc) Progress(e ProgressEvent) {
+	f(e)
+}
+
+// WithProgress sets the ProgressObserver.
+func WithProgress(o ProgressObserver) MapReduceOption {
+	return func(r *MapReduce) {
+		r.progress = o
+	}
+}
+
+// emit updates the summary and notifies the ProgressObserver.
+func (j *job) emit(e ProgressEvent) {
+	j.mu.Lock()
+	switch e.Type {
+	case FileSucceeded:
+		j.summary.Succeeded++
+	case FileFailed:
+		j.summary.Failed++
+	case FileRetried:
+		j.summary.Retried++
+	case FileCached:
+		j.summary.Cached++
+	}
+	j.mu.Unlock()
+
+	if j.r.progress == nil {
+		return
+	}
+
+	e.JobID = j.spec.ID
+	j.r.progress.Progress(e)
+}
+
+// scheduled records the number of files of the job.
+func (j *job) scheduled(files int) {
+	j.mu.Lock()
+	j.summary.Files = files
+	j.mu.Unlock()
+}
+
+// finish emits JobFinished and returns the summary.
+func (j *job) finish(keys int, err error) JobSummary {
+	j.mu.Lock()
+	j.summary.Keys = keys
+	j.summary.Duration = time.Since(j.start)
+	j.summary.Err = err
+	s := j.summary
+	j.mu.Unlock()
+
+	if err != nil {
+		j.logger.Error("job failed", "files", s.Files, "duration", s.Duration, "err", err)
+	} else {
+		j.logger.Info("job finished", "files", s.Files, "keys", keys, "duration", s.Duration)
+	}
+
+	j.emit(ProgressEvent{
+		Type:     JobFinished,
+		Duration: s.Duration,
+		Keys:     keys,
+		Err:      err,
+		Summary:  s,
+	})
+
+	return s
+}
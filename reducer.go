@@ -1,10 +1,13 @@
 package mapreduce
 
+import "fmt"
+
 // Reducer reduces a slice data points into a smaller set.
 type Reducer interface {
 	// Reduce is called with marshalled data either from a mapper or
 	// a reducer. It will be invoked until a slice of length 1
-	// or a non-nil error is returned. Reduce is expected to know how
+	// or a non-nil error is returned. Each invocation has to return
+	// fewer values than it was given. Reduce is expected to know how
 	// to marshal and unmarshal the given data.
 	Reduce(value [][]byte) (reduced [][]byte, err error)
 }
@@ -16,3 +19,74 @@ type ReduceFunc func(value [][]byte) (reduced [][]byte, err error)
 func (f ReduceFunc) Reduce(value [][]byte) (reduced [][]byte, err error) {
 	return f(value)
 }
+
+// NonConvergingError is returned when a Reducer does not reduce a key to a
+// single value.
+type NonConvergingError struct {
+	AlgName string
+
+	// File is empty when the coordinator reduces the results of several
+	// files.
+	File string
+
+	Key string
+
+	// Iterations is the number of invocations of the Reducer.
+	Iterations int
+
+	// Input and Output are the lengths of the slices of the last
+	// iteration. They are both 0 if the maximum number of iterations was
+	// reached.
+	Input, Output int
+}
+
+// Error implements error.
+func (e *NonConvergingError) Error() string {
+	if e.Input == 0 && e.Output == 0 {
+		return fmt.Sprintf("algorithm %s did not reduce key %q (file=%q) within %d iterations", e.AlgName, e.Key, e.File, e.Iterations)
+	}
+
+	return fmt.Sprintf("algorithm %s did not shrink key %q (file=%q): reduced %d values to %d", e.AlgName, e.Key, e.File, e.Input, e.Output)
+}
+
+// reduceAll invokes the Reducer until a single value is left. Every
+// iteration has to shrink the values. A maxIterations greater than 0 limits
+// the number of iterations. It also returns the number of invocations of the
+// Reducer. The reduced value is nil if the Reducer returns no values.
+func reduceAll(r Reducer, values [][]byte, algName, file, key string, maxIterations int) (reduced []byte, iterations int, err error) {
+	for i := 0; len(values) > 1; i++ {
+		if maxIterations > 0 && i >= maxIterations {
+			return nil, i, &NonConvergingError{
+				AlgName:    algName,
+				File:       file,
+				Key:        key,
+				Iterations: i,
+			}
+		}
+
+		iterations = i + 1
+		out, err := safeReduce(r, values, algName, file, key)
+		if err != nil {
+			return nil, iterations, err
+		}
+
+		if len(out) >= len(values) {
+			return nil, iterations, &NonConvergingError{
+				AlgName:    algName,
+				File:       file,
+				Key:        key,
+				Iterations: i + 1,
+				Input:      len(values),
+				Output:     len(out),
+			}
+		}
+
+		values = out
+	}
+
+	if len(values) == 0 {
+		return nil, iterations, nil
+	}
+
+	return values[0], iterations, nil
+}
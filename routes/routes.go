@@ -0,0 +1,440 @@
+// routes provides the standard grammar for routes (see
+// mapreduce.FileSystem). A route is a path of segments that are separated by
+// "/". Each segment is one of:
+//
+//	logs                       a literal
+//	app-*.log                  a glob (see path.Match)
+//	{us,eu}-*                  alternatives, each one a glob
+//	**                         any number of segments
+//	[2024-01-01..2024-01-31]   an inclusive date range; a bound may be empty
+//	region=us                  a key=value partition; the value may be any
+//	                           of the above but **
+//
+// A route matches a file if it matches the leading segments of the file's
+// path, so a route that names a directory matches every file below it. A
+// route has at most 64 segments and each segment at most 64 alternatives.
+package routes
+
+import (
+	"fmt"
+	"path"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// The limits of a route.
+const (
+	maxSegments     = 64
+	maxAlternatives = 64
+)
+
+// SyntaxError is returned by Parse for an invalid route.
+type SyntaxError struct {
+	Route   string
+	Segment string
+	Msg     string
+}
+
+// Error implements error.
+func (e *SyntaxError) Error() string {
+	if e.Segment == "" {
+		return fmt.Sprintf("invalid route %q: %s", e.Route, e.Msg)
+	}
+
+	return fmt.Sprintf("invalid route %q: segment %q: %s", e.Route, e.Segment, e.Msg)
+}
+
+// Pattern is a parsed route.
+type Pattern struct {
+	route    string
+	segments []segment
+}
+
+// segment matches a single segment of a path.
+type segment struct {
+	// any is set for **.
+	any bool
+
+	// key is set for key=value partitions.
+	key   string
+	value valueMatcher
+}
+
+type valueMatcher interface {
+	match(value string) bool
+}
+
+// Parse parses the route.
+func Parse(route string) (Pattern, error) {
+	parts := split(route)
+	if len(parts) > maxSegments {
+		return Pattern{}, &SyntaxError{Route: route, Msg: fmt.Sprintf("more than %d segments", maxSegments)}
+	}
+
+	p := Pattern{route: route}
+	for _, s := range parts {
+		seg, msg := parseSegment(s)
+		if msg != "" {
+			return Pattern{}, &SyntaxError{Route: route, Segment: s, Msg: msg}
+		}
+
+		// Consecutive ** match the same paths as a single one.
+		if seg.any && len(p.segments) > 0 && p.segments[len(p.segments)-1].any {
+			continue
+		}
+		p.segments = append(p.segments, seg)
+	}
+
+	if len(p.segments) == 0 {
+		return Pattern{}, &SyntaxError{Route: route, Msg: "empty route"}
+	}
+
+	return p, nil
+}
+
+// MustParse is like Parse, but it panics if the route is invalid.
+func MustParse(route string) Pattern {
+	p, err := Parse(route)
+	if err != nil {
+		panic(err)
+	}
+
+	return p
+}
+
+// String returns the route.
+func (p Pattern) String() string {
+	return p.route
+}
+
+// Match reports if the file matches the route.
+func (p Pattern) Match(file string) bool {
+	return match(p.segments, split(file), false)
+}
+
+// MayMatch reports if files below the directory might match the route. It is
+// used to skip whole directories.
+func (p Pattern) MayMatch(dir string) bool {
+	return match(p.segments, split(dir), true)
+}
+
+// Filter returns the files that match the route. It is meant for the result
+// of mapreduce.FileSystem.Files.
+func (p Pattern) Filter(files map[string][]string) map[string][]string {
+	matched := make(map[string][]string)
+	for file, ids := range files {
+		if p.Match(file) {
+			matched[file] = ids
+		}
+	}
+
+	return matched
+}
+
+// Partitions returns the key=value partitions of the path.
+func Partitions(file string) map[string]string {
+	partitions := make(map[string]string)
+	for _, s := range split(file) {
+		if key, value, ok := strings.Cut(s, "="); ok {
+			partitions[key] = value
+		}
+	}
+
+	return partitions
+}
+
+// match reports if the segments match the path. If dir is set, a path that
+// ends before the segments is a match.
+func match(segments []segment, parts []string, dir bool) bool {
+	// matched caches if segments[i:] match parts[j:] at i*(len(parts)+1)+j
+	// so ** does not backtrack over the same parts again.
+	matched := make([]int8, len(segments)*(len(parts)+1))
+
+	var rec func(i, j int) bool
+	rec = func(i, j int) bool {
+		if i == len(segments) {
+			// The route matches the leading segments.
+			return true
+		}
+
+		k := i*(len(parts)+1) + j
+		if matched[k] != 0 {
+			return matched[k] > 0
+		}
+
+		var ok bool
+		switch seg := segments[i]; {
+		case seg.any:
+			ok = rec(i+1, j) || (j < len(parts) && rec(i, j+1))
+		case j == len(parts):
+			ok = dir
+		default:
+			ok = seg.match(parts[j]) && rec(i+1, j+1)
+		}
+
+		matched[k] = -1
+		if ok {
+			matched[k] = 1
+		}
+		return ok
+	}
+
+	return rec(0, 0)
+}
+
+func (s segment) match(part string) bool {
+	if s.key == "" {
+		return s.value.match(part)
+	}
+
+	key, value, ok := strings.Cut(part, "=")
+	return ok && key == s.key && s.value.match(value)
+}
+
+func split(p string) []string {
+	var parts []string
+	for _, s := range strings.Split(p, "/") {
+		if s != "" {
+			parts = append(parts, s)
+		}
+	}
+
+	return parts
+}
+
+func parseSegment(s string) (segment, string) {
+	if s == "**" {
+		return segment{any: true}, ""
+	}
+
+	var seg segment
+	if key, value, ok := strings.Cut(s, "="); ok {
+		if key == "" || strings.ContainsAny(key, "*?[]{}") {
+			return segment{}, "invalid partition key"
+		}
+		if value == "**" {
+			return segment{}, "** can not be a partition value"
+		}
+		seg.key, s = key, value
+	}
+
+	value, msg := parseValue(s)
+	seg.value = value
+	return seg, msg
+}
+
+func parseValue(s string) (valueMatcher, string) {
+	if strings.HasPrefix(s, "[") && strings.HasSuffix(s, "]") && strings.Contains(s, "..") {
+		return parseRange(s[1 : len(s)-1])
+	}
+
+	if !strings.ContainsAny(s, "{}") {
+		return parseGlob(s)
+	}
+
+	return parseAlternatives(s)
+}
+
+type glob string
+
+func parseGlob(s string) (glob, string) {
+	if s == "" {
+		return "", "empty value"
+	}
+
+	if _, err := path.Match(s, ""); err != nil {
+		return "", err.Error()
+	}
+
+	return glob(s), ""
+}
+
+func (g glob) match(value string) bool {
+	ok, _ := path.Match(string(g), value)
+	return ok
+}
+
+// alternatives is a glob with groups of alternatives (e.g., {us,eu}-*). Each
+// element holds the globs of a group or the glob between two groups. The
+// groups are matched one after another instead of expanding every
+// combination.
+type alternatives [][]glob
+
+func parseAlternatives(s string) (valueMatcher, string) {
+	if strings.Trim(s, "{},") == "" {
+		return nil, "empty value"
+	}
+
+	var a alternatives
+	var count int
+	for s != "" {
+		start := strings.IndexByte(s, '{')
+		if start < 0 {
+			start = len(s)
+		}
+		if strings.Contains(s[:start], "}") {
+			return nil, "unbalanced braces"
+		}
+
+		if start > 0 {
+			g, msg := parseGlob(s[:start])
+			if msg != "" {
+				return nil, msg
+			}
+			a = append(a, []glob{g})
+		}
+
+		if start == len(s) {
+			break
+		}
+
+		end := strings.IndexByte(s[start:], '}')
+		if end < 0 || strings.Contains(s[start+1:start+end], "{") {
+			return nil, "unbalanced braces"
+		}
+		end += start
+
+		var group []glob
+		for _, alt := range strings.Split(s[start+1:end], ",") {
+			if count++; count > maxAlternatives {
+				return nil, fmt.Sprintf("more than %d alternatives", maxAlternatives)
+			}
+
+			// An empty alternative makes the group optional.
+			if alt == "" {
+				group = append(group, "")
+				continue
+			}
+
+			g, msg := parseGlob(alt)
+			if msg != "" {
+				return nil, msg
+			}
+			group = append(group, g)
+		}
+		a = append(a, group)
+
+		s = s[end+1:]
+	}
+
+	return a, ""
+}
+
+func (a alternatives) match(value string) bool {
+	// matched caches if a[i:] matches value[k:] at i*(len(value)+1)+k.
+	matched := make([]int8, len(a)*(len(value)+1))
+
+	var rec func(i, k int) bool
+	rec = func(i, k int) bool {
+		if i == len(a) {
+			return k == len(value)
+		}
+
+		idx := i*(len(value)+1) + k
+		if matched[idx] != 0 {
+			return matched[idx] > 0
+		}
+
+		ok := a.matchAt(i, value, k, rec)
+
+		matched[idx] = -1
+		if ok {
+			matched[idx] = 1
+		}
+		return ok
+	}
+
+	return rec(0, 0)
+}
+
+// matchAt reports if a glob of the element i matches value[k:end] and the
+// rest matches value[end:] for any end.
+func (a alternatives) matchAt(i int, value string, k int, rest func(i, k int) bool) bool {
+	for end := k; end <= len(value); end++ {
+		if end < len(value) && !utf8.RuneStart(value[end]) {
+			continue
+		}
+
+		for _, g := range a[i] {
+			if g.match(value[k:end]) && rest(i+1, end) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// dateLayouts are the supported layouts of date ranges.
+var dateLayouts = []string{
+	"2006-01-02T15:04:05",
+	"2006-01-02T15:04",
+	"2006-01-02T15",
+	"2006-01-02",
+	"2006-01",
+	"2006",
+}
+
+// dateRange matches dates within [from, to]. Values are parsed with the
+// layout of the bounds.
+type dateRange struct {
+	layout   string
+	from, to time.Time
+}
+
+func parseRange(s string) (valueMatcher, string) {
+	from, to, _ := strings.Cut(s, "..")
+	if from == "" && to == "" {
+		return nil, "date range without bounds"
+	}
+
+	r := dateRange{layout: layoutOf(from)}
+	if from == "" {
+		r.layout = layoutOf(to)
+	}
+	if r.layout == "" {
+		return nil, "invalid date range"
+	}
+
+	var err error
+	if from != "" {
+		if r.from, err = time.Parse(r.layout, from); err != nil {
+			return nil, "invalid date range"
+		}
+	}
+	if to != "" {
+		if r.to, err = time.Parse(r.layout, to); err != nil {
+			return nil, "bounds of the date range have different layouts"
+		}
+	}
+
+	if !r.from.IsZero() && !r.to.IsZero() && r.to.Before(r.from) {
+		return nil, "date range ends before it starts"
+	}
+
+	return r, ""
+}
+
+func layoutOf(s string) string {
+	for _, layout := range dateLayouts {
+		if _, err := time.Parse(layout, s); err == nil {
+			return layout
+		}
+	}
+
+	return ""
+}
+
+func (r dateRange) match(value string) bool {
+	t, err := time.Parse(r.layout, value)
+	if err != nil {
+		return false
+	}
+
+	if !r.from.IsZero() && t.Before(r.from) {
+		return false
+	}
+
+	return r.to.IsZero() || !t.After(r.to)
+}
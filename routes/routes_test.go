@@ -0,0 +1,123 @@
+package routes_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/poy/mapreduce/routes"
+	"github.com/poy/onpar"
+	. "github.com/poy/onpar/expect"
+	. "github.com/poy/onpar/matchers"
+)
+
+type TR struct {
+	*testing.T
+}
+
+func TestRoutes(t *testing.T) {
+	t.Parallel()
+	o := onpar.New()
+	defer o.Run(t)
+
+	o.BeforeEach(func(t *testing.T) TR {
+		return TR{T: t}
+	})
+
+	o.Spec("it matches literals, globs and alternatives", func(t TR) {
+		p := routes.MustParse("logs/{us,eu}-*/app-?.log")
+
+		Expect(t, p.Match("logs/us-east/app-1.log")).To(BeTrue())
+		Expect(t, p.Match("/logs/eu-west/app-2.log")).To(BeTrue())
+		Expect(t, p.Match("logs/ap-south/app-1.log")).To(BeFalse())
+		Expect(t, p.Match("logs/us-east/app-10.log")).To(BeFalse())
+	})
+
+	o.Spec("it matches every file below a directory", func(t TR) {
+		p := routes.MustParse("logs/us")
+
+		Expect(t, p.Match("logs/us/a/b.log")).To(BeTrue())
+		Expect(t, p.Match("logs")).To(BeFalse())
+	})
+
+	o.Spec("it matches any number of segments", func(t TR) {
+		p := routes.MustParse("logs/**/*.log")
+
+		Expect(t, p.Match("logs/a.log")).To(BeTrue())
+		Expect(t, p.Match("logs/a/b/c.log")).To(BeTrue())
+		Expect(t, p.Match("logs/a/b/c.txt")).To(BeFalse())
+	})
+
+	o.Spec("it matches partitions and date ranges", func(t TR) {
+		p := routes.MustParse("events/region=*/date=[2024-01-01..2024-01-31]")
+
+		Expect(t, p.Match("events/region=us/date=2024-01-01/part-0")).To(BeTrue())
+		Expect(t, p.Match("events/region=eu/date=2024-01-31/part-0")).To(BeTrue())
+		Expect(t, p.Match("events/region=eu/date=2024-02-01/part-0")).To(BeFalse())
+		Expect(t, p.Match("events/zone=eu/date=2024-01-05/part-0")).To(BeFalse())
+		Expect(t, p.Match("events/region=eu/date=yesterday/part-0")).To(BeFalse())
+
+		open := routes.MustParse("events/*/date=[2024-01-15T00..]")
+		Expect(t, open.Match("events/region=eu/date=2024-01-15T03")).To(BeTrue())
+		Expect(t, open.Match("events/region=eu/date=2024-01-14T23")).To(BeFalse())
+	})
+
+	o.Spec("it prunes directories", func(t TR) {
+		p := routes.MustParse("events/region={us,eu}/date=[2024-01-01..2024-01-31]")
+
+		Expect(t, p.MayMatch("events")).To(BeTrue())
+		Expect(t, p.MayMatch("events/region=us")).To(BeTrue())
+		Expect(t, p.MayMatch("events/region=ap")).To(BeFalse())
+		Expect(t, p.MayMatch("events/region=us/date=2023-12-31")).To(BeFalse())
+	})
+
+	o.Spec("it filters files", func(t TR) {
+		p := routes.MustParse("a/*")
+		files := p.Filter(map[string][]string{
+			"a/1": {"node-a"},
+			"b/1": {"node-b"},
+		})
+
+		Expect(t, files).To(Equal(map[string][]string{"a/1": {"node-a"}}))
+	})
+
+	o.Spec("it returns the partitions of a path", func(t TR) {
+		Expect(t, routes.Partitions("events/region=us/date=2024-01-01/part-0")).To(Equal(map[string]string{
+			"region": "us",
+			"date":   "2024-01-01",
+		}))
+	})
+
+	o.Spec("it matches many alternatives and ** quickly", func(t TR) {
+		p := routes.MustParse(strings.Repeat("{a,b}", 22))
+		Expect(t, p.Match(strings.Repeat("ab", 11))).To(BeTrue())
+		Expect(t, p.Match(strings.Repeat("ab", 11)+"c")).To(BeFalse())
+
+		deep := routes.MustParse(strings.Repeat("**/", 12) + "z")
+		Expect(t, deep.Match(strings.Repeat("a/", 26))).To(BeFalse())
+		Expect(t, deep.Match(strings.Repeat("a/", 25)+"z")).To(BeTrue())
+
+		opt := routes.MustParse("app{,-v2}.log")
+		Expect(t, opt.Match("app.log")).To(BeTrue())
+		Expect(t, opt.Match("app-v2.log")).To(BeTrue())
+	})
+
+	o.Spec("it rejects invalid routes", func(t TR) {
+		for _, route := range []string{
+			"",
+			"logs/[a",
+			"logs/=us",
+			"logs/region=**",
+			"logs/{a,b",
+			"logs/[2024-01-31..2024-01-01]",
+			"logs/[2024-01-01..2024-02]",
+			"logs/[a..b]",
+			"logs/{}",
+			strings.Repeat("a/", 65),
+			"logs/{" + strings.Repeat("a,", 64) + "a}",
+		} {
+			_, err := routes.Parse(route)
+			_, ok := err.(*routes.SyntaxError)
+			Expect(t, ok).To(BeTrue())
+		}
+	})
+}
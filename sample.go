@@ -0,0 +1,342 @@
+package mapreduce
+
+import (
+	"hash/fnv"
+	"math"
+	"math/rand"
+	"sort"
+	"strings"
+
+	"golang.org/x/net/context"
+)
+
+// Sample configures an approximate job (see JobSpec.Sample). The zero value
+// processes every file and record.
+type Sample struct {
+	// FileFraction is the fraction of the files returned by the FileSystem
+	// that are processed. A value of 0 or 1 (or any value outside of (0, 1),
+	// including NaN) processes every file.
+	FileFraction float64
+
+	// Stratified samples the FileFraction of the files of each set of nodes
+	// instead of all files so every node is represented.
+	Stratified bool
+
+	// RecordFraction is the fraction of the records of each file that the
+	// Executor maps. Like FileFraction, a value of 0 or 1 maps every record.
+	RecordFraction float64
+
+	// Seed makes the sample repeatable. A random seed is picked if it is 0
+	// (see Estimate.Seed).
+	Seed int64
+}
+
+// samplesFiles reports if only a fraction of the files is processed.
+func (s Sample) samplesFiles() bool {
+	return s.FileFraction > 0 && s.FileFraction < 1
+}
+
+// samplesRecords reports if only a fraction of the records is mapped.
+func (s Sample) samplesRecords() bool {
+	return s.RecordFraction > 0 && s.RecordFraction < 1
+}
+
+// seeded picks a random Seed if the Sample has none.
+func (s Sample) seeded() Sample {
+	if !s.samplesFiles() && !s.samplesRecords() {
+		return s
+	}
+
+	for s.Seed == 0 {
+		s.Seed = rand.Int63()
+	}
+
+	return s
+}
+
+// finite replaces the fractions that can not be encoded (NaN and ±Inf) by
+// 0. Like 0, they process everything.
+func (s Sample) finite() Sample {
+	if math.IsNaN(s.FileFraction) || math.IsInf(s.FileFraction, 0) {
+		s.FileFraction = 0
+	}
+
+	if math.IsNaN(s.RecordFraction) || math.IsInf(s.RecordFraction, 0) {
+		s.RecordFraction = 0
+	}
+
+	return s
+}
+
+// Estimator is implemented by Reducers of counts and sums. The results of a
+// sample are scaled to the whole data and bounded only if the Reducer is an
+// Estimator.
+type Estimator interface {
+	// ParseEstimate returns the number of the reduced value.
+	ParseEstimate(reduced []byte) (float64, error)
+
+	// FormatEstimate returns the reduced value of the number.
+	FormatEstimate(v float64) []byte
+}
+
+// Estimate is the result of a job that processed a sample.
+type Estimate struct {
+	// Result is the reduced data for each key. The values are scaled to
+	// the whole data if the Reducer implements Estimator.
+	Result map[string][]byte
+
+	// Bounds is the half-width of the 95% confidence interval of each key.
+	// It is only set if the Reducer implements Estimator. The part due to
+	// the sampling of records assumes that each record adds 1 (a count).
+	Bounds map[string]float64
+
+	// Files is the number of files returned by the FileSystem and
+	// SampledFiles the number of processed files.
+	Files        int
+	SampledFiles int
+
+	// Seed is the seed of the sample. It is 0 if nothing was sampled.
+	Seed int64
+}
+
+// CalculateEstimate is like Calculate, but it also returns the error bounds
+// of the JobSpec's Sample.
+func (r MapReduce) CalculateEstimate(route, algName string, ctx context.Context, meta []byte) (Estimate, error) {
+	return r.calculate(route, algName, ctx, meta)
+}
+
+// stratum is a group of files that is sampled separately.
+type stratum struct {
+	// total and sampled are the number of files.
+	total, sampled int
+}
+
+// factor is the scale of the results of the stratum.
+func (s *stratum) factor() float64 {
+	return float64(s.total) / float64(s.sampled)
+}
+
+// sampleFiles returns the files of the Sample and the stratum of each
+// sampled file. The strata are nil if every file is processed.
+func sampleFiles(files map[string][]string, s Sample) (map[string][]string, map[string]*stratum) {
+	if !s.samplesFiles() {
+		return files, nil
+	}
+
+	groups := make(map[string][]string)
+	for fileName, ids := range files {
+		var key string
+		if s.Stratified {
+			sorted := append([]string(nil), ids...)
+			sort.Strings(sorted)
+			key = strings.Join(sorted, ",")
+		}
+		groups[key] = append(groups[key], fileName)
+	}
+
+	var keys []string
+	for key := range groups {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	// The files are sorted so the same seed picks the same files.
+	rnd := rand.New(rand.NewSource(s.Seed))
+	sampled := make(map[string][]string)
+	strata := make(map[string]*stratum)
+	for _, key := range keys {
+		names := groups[key]
+		sort.Strings(names)
+		rnd.Shuffle(len(names), func(i, j int) {
+			names[i], names[j] = names[j], names[i]
+		})
+
+		st := &stratum{
+			total:   len(names),
+			sampled: int(math.Ceil(s.FileFraction * float64(len(names)))),
+		}
+		for _, fileName := range names[:st.sampled] {
+			sampled[fileName] = files[fileName]
+			strata[fileName] = st
+		}
+	}
+
+	return sampled, strata
+}
+
+// recordSampler picks the records of a file.
+type recordSampler struct {
+	fraction float64
+	rnd      *rand.Rand
+
+	// read and kept are the number of records.
+	read, kept int
+}
+
+// newRecordSampler returns nil if every record is mapped.
+func newRecordSampler(s Sample, fileName string) *recordSampler {
+	if !s.samplesRecords() {
+		return nil
+	}
+
+	s = s.seeded()
+	h := fnv.New64a()
+	h.Write([]byte(fileName))
+
+	return &recordSampler{
+		fraction: s.RecordFraction,
+		rnd:      rand.New(rand.NewSource(s.Seed ^ int64(h.Sum64()))),
+	}
+}
+
+// keep reports if the next record is part of the sample.
+func (s *recordSampler) keep() bool {
+	if s == nil {
+		return true
+	}
+
+	s.read++
+	if s.rnd.Float64() >= s.fraction {
+		return false
+	}
+	s.kept++
+
+	return true
+}
+
+// scale scales the result to every record of the file.
+func (s *recordSampler) scale(r Reducer, result map[string][]byte, algName, fileName string) (map[string][]byte, error) {
+	if s == nil || s.kept == 0 {
+		return result, nil
+	}
+
+	return scaleResult(r, result, float64(s.read)/float64(s.kept), algName, fileName)
+}
+
+// scaleResult multiplies each value by the factor if the Reducer is an
+// Estimator.
+func scaleResult(r Reducer, result map[string][]byte, factor float64, algName, fileName string) (map[string][]byte, error) {
+	e, ok := r.(Estimator)
+	if !ok || factor == 1 {
+		return result, nil
+	}
+
+	scaled := make(map[string][]byte, len(result))
+	for key, value := range result {
+		v, err := safeParseEstimate(e, value, algName, fileName, key)
+		if err != nil {
+			return nil, err
+		}
+
+		scaled[key], err = safeFormatEstimate(e, v*factor, algName, fileName, key)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return scaled, nil
+}
+
+// estimator collects the results of the sampled files to compute the error
+// bounds.
+type estimator struct {
+	r       Reducer
+	e       Estimator
+	algName string
+	sample  Sample
+	strata  map[string]*stratum
+
+	// values holds the values of each key for the files of each stratum.
+	// Files without the key are left out.
+	values map[*stratum]map[string][]float64
+}
+
+// newEstimator returns nil if the job is not approximate or the Reducer is
+// not an Estimator.
+func newEstimator(r Reducer, algName string, s Sample, strata map[string]*stratum) *estimator {
+	e, ok := r.(Estimator)
+	if !ok || (!s.samplesFiles() && !s.samplesRecords()) {
+		return nil
+	}
+
+	return &estimator{
+		r:       r,
+		e:       e,
+		algName: algName,
+		sample:  s,
+		strata:  strata,
+		values:  make(map[*stratum]map[string][]float64),
+	}
+}
+
+// add scales the result of the file to its stratum and records its values.
+func (e *estimator) add(fileName string, result map[string][]byte) (map[string][]byte, error) {
+	if e == nil {
+		return result, nil
+	}
+
+	st, ok := e.strata[fileName]
+	if !ok {
+		return result, nil
+	}
+
+	if e.values[st] == nil {
+		e.values[st] = make(map[string][]float64)
+	}
+
+	for key, value := range result {
+		v, err := safeParseEstimate(e.e, value, e.algName, fileName, key)
+		if err != nil {
+			return nil, err
+		}
+		e.values[st][key] = append(e.values[st][key], v)
+	}
+
+	return scaleResult(e.r, result, st.factor(), e.algName, fileName)
+}
+
+// bounds returns the half-width of the 95% confidence interval of each key.
+func (e *estimator) bounds(result map[string][]byte) (map[string]float64, error) {
+	if e == nil {
+		return nil, nil
+	}
+
+	variance := make(map[string]float64, len(result))
+	for st, keys := range e.values {
+		// The variance of a single file is unknown.
+		if st.sampled < 2 {
+			continue
+		}
+
+		k := float64(st.sampled)
+		for key, values := range keys {
+			var sum, sumSq float64
+			for _, v := range values {
+				sum += v
+				sumSq += v * v
+			}
+
+			// The files without the key add 0.
+			mean := sum / k
+			s2 := (sumSq - k*mean*mean) / (k - 1)
+			n := float64(st.total)
+			variance[key] += n * n * (1 - k/n) * s2 / k
+		}
+	}
+
+	bounds := make(map[string]float64, len(result))
+	for key, value := range result {
+		if e.sample.samplesRecords() {
+			v, err := safeParseEstimate(e.e, value, e.algName, "", key)
+			if err != nil {
+				return nil, err
+			}
+			p := e.sample.RecordFraction
+			variance[key] += v * (1 - p) / p
+		}
+
+		bounds[key] = 1.96 * math.Sqrt(variance[key])
+	}
+
+	return bounds, nil
+}
@@ -19,3 +19,8 @@ func NewInProcessNetwork(e *mapreduce.Executor) *InProcessNetwork {
 func (n *InProcessNetwork) Execute(file, algName, nodeID string, ctx context.Context, meta []byte) (result map[string][]byte, err error) {
 	return n.e.Execute(file, algName, ctx, meta)
 }
+
+func (n *InProcessNetwork) CancelJob(jobID, nodeID string, ctx context.Context) error {
+	n.e.CancelJob(jobID)
+	return nil
+}
@@ -0,0 +1,316 @@
+// script is used to run algorithms that are written in Starlark and shipped
+// with the JobSpec. This allows ad-hoc algorithms without redeploying the
+// coordinator and the nodes.
+//
+// A script has to define the functions map and reduce:
+//
+//	def map(value):
+//	    # value is bytes. Return None to filter out the value.
+//	    return ("key", value)
+//
+//	def reduce(values):
+//	    # values is a list of bytes.
+//	    return [values[0]]
+//
+// Outputs can be bytes or strings. Scripts can not access the file system
+// or the network. The steps, duration and output of each map and reduce call
+// are limited, but its memory is not (see WithMaxOutputBytes).
+package script
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/poy/mapreduce"
+	"github.com/poy/mapreduce/internal/lru"
+	"go.starlark.net/starlark"
+)
+
+// Fetcher implements mapreduce.AlgorithmFetcher. It builds the algorithm
+// from the Script of the JobSpec that is encoded in the meta information.
+// The version of the algorithm is the content hash of the script.
+//
+// A Fetcher has to be created with NewFetcher().
+type Fetcher struct {
+	// TODO: Limit the memory of each map and reduce call. Starlark does not
+	// account for allocations, so the calls have to run in a sandboxed
+	// process.
+	maxSteps       uint64
+	timeout        time.Duration
+	maxOutputBytes int
+	maxPrograms    int
+
+	mu       sync.Mutex
+	programs *lru.Cache
+}
+
+// FetcherOption is used to configure a new Fetcher.
+type FetcherOption func(*Fetcher)
+
+// WithMaxSteps sets the maximum number of Starlark execution steps for a
+// single map or reduce call. It defaults to 100000.
+func WithMaxSteps(steps uint64) FetcherOption {
+	return func(f *Fetcher) {
+		f.maxSteps = steps
+	}
+}
+
+// WithTimeout sets the maximum duration of a single map or reduce call. It
+// defaults to 1 second.
+func WithTimeout(d time.Duration) FetcherOption {
+	return func(f *Fetcher) {
+		f.timeout = d
+	}
+}
+
+// WithMaxOutputBytes sets the maximum number of bytes a single map or reduce
+// call can return. It defaults to 1MiB. It does not bound the memory a call
+// uses: Starlark does not account for memory, so a single step (e.g.,
+// b"x" * (1 << 29)) can allocate any amount. Untrusted scripts have to run in
+// a process with a memory limit.
+func WithMaxOutputBytes(n int) FetcherOption {
+	return func(f *Fetcher) {
+		f.maxOutputBytes = n
+	}
+}
+
+// WithMaxPrograms sets the number of compiled scripts that are cached. The
+// least recently used script is dropped. It defaults to 128.
+func WithMaxPrograms(n int) FetcherOption {
+	return func(f *Fetcher) {
+		f.maxPrograms = n
+	}
+}
+
+// NewFetcher returns a new Fetcher.
+func NewFetcher(opts ...FetcherOption) *Fetcher {
+	f := &Fetcher{
+		maxSteps:       100000,
+		timeout:        time.Second,
+		maxOutputBytes: 1 << 20,
+		maxPrograms:    128,
+	}
+
+	for _, o := range opts {
+		o(f)
+	}
+	f.programs = lru.New(f.maxPrograms)
+
+	return f
+}
+
+// Alg implements mapreduce.AlgorithmFetcher.
+func (f *Fetcher) Alg(name string, meta []byte) (mapreduce.Algorithm, error) {
+	spec, err := mapreduce.UnmarshalJobSpec(meta)
+	if err != nil {
+		return mapreduce.Algorithm{}, fmt.Errorf("script %s: %s", name, err)
+	}
+
+	if spec.Script == "" {
+		return mapreduce.Algorithm{}, fmt.Errorf("script %s: the JobSpec does not have a script", name)
+	}
+
+	sum := sha256.Sum256([]byte(spec.Script))
+	version := "sha256:" + hex.EncodeToString(sum[:])
+
+	f.mu.Lock()
+	cached, ok := f.programs.Get(version)
+	f.mu.Unlock()
+	if ok {
+		return cached.(mapreduce.Algorithm), nil
+	}
+
+	// The top level of the script runs without the lock so it does not
+	// block other scripts. A script might be compiled twice.
+	alg, err := f.compile(name, spec.Script)
+	if err != nil {
+		return mapreduce.Algorithm{}, err
+	}
+	alg.Version = version
+
+	f.mu.Lock()
+	f.programs.Add(version, alg)
+	f.mu.Unlock()
+
+	return alg, nil
+}
+
+// compile runs the script and looks up its map and reduce functions.
+func (f *Fetcher) compile(name, src string) (mapreduce.Algorithm, error) {
+	thread := f.newThread(name)
+	stop := f.watch(thread)
+	globals, err := starlark.ExecFile(thread, name, src, nil)
+	stop()
+	if err != nil {
+		return mapreduce.Algorithm{}, fmt.Errorf("script %s: %s", name, err)
+	}
+
+	mapFn, err := lookup(globals, name, "map")
+	if err != nil {
+		return mapreduce.Algorithm{}, err
+	}
+
+	reduceFn, err := lookup(globals, name, "reduce")
+	if err != nil {
+		return mapreduce.Algorithm{}, err
+	}
+
+	s := &scriptAlg{
+		f:        f,
+		name:     name,
+		mapFn:    mapFn,
+		reduceFn: reduceFn,
+	}
+
+	return mapreduce.Algorithm{
+		Mapper:  mapreduce.MapFunc(s.Map),
+		Reducer: mapreduce.ReduceFunc(s.Reduce),
+	}, nil
+}
+
+// newThread returns a thread with the configured step limit. Output from
+// print() is dropped.
+func (f *Fetcher) newThread(name string) *starlark.Thread {
+	thread := &starlark.Thread{
+		Name:  name,
+		Print: func(*starlark.Thread, string) {},
+	}
+	thread.SetMaxExecutionSteps(f.maxSteps)
+
+	return thread
+}
+
+// watch cancels the thread after the configured timeout. The returned
+// function has to be invoked once the thread is done.
+func (f *Fetcher) watch(thread *starlark.Thread) (stop func()) {
+	t := time.AfterFunc(f.timeout, func() {
+		thread.Cancel(fmt.Sprintf("exceeded timeout of %s", f.timeout))
+	})
+
+	return func() { t.Stop() }
+}
+
+func lookup(globals starlark.StringDict, name, fn string) (starlark.Callable, error) {
+	v, ok := globals[fn]
+	if !ok {
+		return nil, fmt.Errorf("script %s: function %s is not defined", name, fn)
+	}
+
+	c, ok := v.(starlark.Callable)
+	if !ok {
+		return nil, fmt.Errorf("script %s: %s is a %s, not a function", name, fn, v.Type())
+	}
+
+	return c, nil
+}
+
+// scriptAlg invokes the functions of a compiled script.
+type scriptAlg struct {
+	f        *Fetcher
+	name     string
+	mapFn    starlark.Callable
+	reduceFn starlark.Callable
+}
+
+// Map implements mapreduce.Mapper.
+func (s *scriptAlg) Map(value []byte) (key string, output []byte, err error) {
+	v, err := s.call(s.mapFn, starlark.Bytes(value))
+	if err != nil {
+		return "", nil, err
+	}
+
+	if v == starlark.None {
+		return "", nil, nil
+	}
+
+	t, ok := v.(starlark.Tuple)
+	if !ok || len(t) != 2 {
+		return "", nil, fmt.Errorf("script %s: map has to return None or (key, output), not %s", s.name, v.Type())
+	}
+
+	k, ok := starlark.AsString(t[0])
+	if !ok {
+		return "", nil, fmt.Errorf("script %s: map returned a %s key, not a string", s.name, t[0].Type())
+	}
+
+	output, err = s.toBytes("map", t[1])
+	if err != nil {
+		return "", nil, err
+	}
+
+	if len(k)+len(output) > s.f.maxOutputBytes {
+		return "", nil, s.outputErr("map")
+	}
+
+	return k, output, nil
+}
+
+// Reduce implements mapreduce.Reducer.
+func (s *scriptAlg) Reduce(value [][]byte) (reduced [][]byte, err error) {
+	values := make([]starlark.Value, 0, len(value))
+	for _, v := range value {
+		values = append(values, starlark.Bytes(v))
+	}
+
+	v, err := s.call(s.reduceFn, starlark.NewList(values))
+	if err != nil {
+		return nil, err
+	}
+
+	iter, ok := v.(starlark.Iterable)
+	if !ok {
+		return nil, fmt.Errorf("script %s: reduce has to return a list, not %s", s.name, v.Type())
+	}
+
+	var size int
+	it := iter.Iterate()
+	defer it.Done()
+
+	var x starlark.Value
+	for it.Next(&x) {
+		b, err := s.toBytes("reduce", x)
+		if err != nil {
+			return nil, err
+		}
+
+		size += len(b)
+		if size > s.f.maxOutputBytes {
+			return nil, s.outputErr("reduce")
+		}
+
+		reduced = append(reduced, b)
+	}
+
+	return reduced, nil
+}
+
+func (s *scriptAlg) call(fn starlark.Callable, arg starlark.Value) (starlark.Value, error) {
+	thread := s.f.newThread(s.name)
+	stop := s.f.watch(thread)
+	defer stop()
+
+	v, err := starlark.Call(thread, fn, starlark.Tuple{arg}, nil)
+	if err != nil {
+		return nil, fmt.Errorf("script %s: %s", s.name, err)
+	}
+
+	return v, nil
+}
+
+func (s *scriptAlg) toBytes(fn string, v starlark.Value) ([]byte, error) {
+	switch x := v.(type) {
+	case starlark.Bytes:
+		return []byte(x), nil
+	case starlark.String:
+		return []byte(x), nil
+	default:
+		return nil, fmt.Errorf("script %s: %s returned a %s, not bytes or a string", s.name, fn, v.Type())
+	}
+}
+
+func (s *scriptAlg) outputErr(fn string) error {
+	return fmt.Errorf("script %s: %s exceeded the output limit of %d bytes", s.name, fn, s.f.maxOutputBytes)
+}
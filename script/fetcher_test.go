@@ -0,0 +1,135 @@
+package script_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/poy/mapreduce"
+	"github.com/poy/mapreduce/script"
+	"github.com/poy/onpar"
+	. "github.com/poy/onpar/expect"
+	. "github.com/poy/onpar/matchers"
+)
+
+const countScript = `
+def map(value):
+    if len(value) == 0:
+        return None
+    if len(value) % 2 == 0:
+        return ("even", "1")
+    return ("odd", "1")
+
+def reduce(values):
+    total = 0
+    for v in values:
+        total += int(str(v))
+    return [str(total)]
+`
+
+type TF struct {
+	*testing.T
+	f *script.Fetcher
+}
+
+func TestFetcher(t *testing.T) {
+	t.Parallel()
+	o := onpar.New()
+	defer o.Run(t)
+
+	o.BeforeEach(func(t *testing.T) TF {
+		return TF{
+			T: t,
+			f: script.NewFetcher(
+				script.WithMaxSteps(10000),
+				script.WithTimeout(time.Second),
+				script.WithMaxOutputBytes(64),
+			),
+		}
+	})
+
+	o.Group("with a valid script", func() {
+		o.Spec("it maps the values", func(t TF) {
+			alg, err := t.f.Alg("count", mapreduce.JobSpec{Script: countScript}.Marshal())
+			Expect(t, err == nil).To(BeTrue())
+
+			key, output, err := alg.Map([]byte("ab"))
+			Expect(t, err == nil).To(BeTrue())
+			Expect(t, key).To(Equal("even"))
+			Expect(t, output).To(Equal([]byte("1")))
+
+			key, _, _ = alg.Map([]byte("abc"))
+			Expect(t, key).To(Equal("odd"))
+		})
+
+		o.Spec("it filters values when map returns None", func(t TF) {
+			alg, _ := t.f.Alg("count", mapreduce.JobSpec{Script: countScript}.Marshal())
+
+			key, _, err := alg.Map(nil)
+			Expect(t, err == nil).To(BeTrue())
+			Expect(t, key).To(HaveLen(0))
+		})
+
+		o.Spec("it reduces the values", func(t TF) {
+			alg, _ := t.f.Alg("count", mapreduce.JobSpec{Script: countScript}.Marshal())
+
+			reduced, err := alg.Reduce([][]byte{[]byte("1"), []byte("2"), []byte("3")})
+			Expect(t, err == nil).To(BeTrue())
+			Expect(t, reduced).To(Equal([][]byte{[]byte("6")}))
+		})
+
+		o.Spec("it uses the content hash as version", func(t TF) {
+			a, _ := t.f.Alg("count", mapreduce.JobSpec{Script: countScript}.Marshal())
+			b, _ := t.f.Alg("count", mapreduce.JobSpec{Script: countScript + "\n"}.Marshal())
+
+			Expect(t, a.Version).To(HaveLen(71))
+			Expect(t, a.Version == b.Version).To(BeFalse())
+		})
+	})
+
+	o.Spec("it enforces the step limit", func(t TF) {
+		alg, err := t.f.Alg("loop", mapreduce.JobSpec{Script: `
+def map(value):
+    for i in range(1000000):
+        pass
+    return ("k", value)
+
+def reduce(values):
+    return values
+`}.Marshal())
+		Expect(t, err == nil).To(BeTrue())
+
+		_, _, err = alg.Map([]byte("a"))
+		Expect(t, err == nil).To(BeFalse())
+	})
+
+	o.Spec("it enforces the output limit", func(t TF) {
+		alg, _ := t.f.Alg("big", mapreduce.JobSpec{Script: `
+def map(value):
+    return ("k", "x" * 100)
+
+def reduce(values):
+    return values
+`}.Marshal())
+
+		_, _, err := alg.Map([]byte("a"))
+		Expect(t, err == nil).To(BeFalse())
+	})
+
+	o.Spec("it returns an error for a missing function", func(t TF) {
+		_, err := t.f.Alg("missing", mapreduce.JobSpec{Script: `
+def map(value):
+    return None
+`}.Marshal())
+		Expect(t, err == nil).To(BeFalse())
+	})
+
+	o.Spec("it returns an error for an invalid script", func(t TF) {
+		_, err := t.f.Alg("invalid", mapreduce.JobSpec{Script: "def map("}.Marshal())
+		Expect(t, err == nil).To(BeFalse())
+	})
+
+	o.Spec("it returns an error without a JobSpec", func(t TF) {
+		_, err := t.f.Alg("count", []byte("ad-hoc-meta"))
+		Expect(t, err == nil).To(BeFalse())
+	})
+}
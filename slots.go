@@ -0,0 +1,175 @@
+package mapreduce
+
+import (
+	"sync"
+
+	"golang.org/x/net/context"
+)
+
+// WithNodeSlots limits the number of concurrent Network.Executes per node
+// across every job. When a node is busy, a free slot is handed to the waiting
+// job that uses the fewest slots of the node, so a large job does not starve
+// the others. A value of 0 (the default) does not limit the
+// Network.Executes.
+func WithNodeSlots(n int) MapReduceOption {
+	return func(r *MapReduce) {
+		if n <= 0 {
+			r.slots = nil
+			return
+		}
+
+		r.slots = &nodeSlots{
+			limit: n,
+			nodes: make(map[string]*nodeQueue),
+		}
+	}
+}
+
+// WaitingForSlot returns the number of Network.Executes that wait for a slot
+// of the node (see WithNodeSlots).
+func (r MapReduce) WaitingForSlot(nodeID string) int {
+	if r.slots == nil {
+		return 0
+	}
+
+	r.slots.mu.Lock()
+	defer r.slots.mu.Unlock()
+
+	q, ok := r.slots.nodes[nodeID]
+	if !ok {
+		return 0
+	}
+
+	var n int
+	for _, u := range q.jobs {
+		n += len(u.waiting)
+	}
+	return n
+}
+
+type nodeSlots struct {
+	limit int
+
+	mu    sync.Mutex
+	seq   int
+	nodes map[string]*nodeQueue
+}
+
+// nodeQueue holds the slots of a node and the jobs that wait for one.
+type nodeQueue struct {
+	used int
+
+	// jobs holds the state of each job that uses or waits for a slot.
+	jobs map[string]*slotUser
+}
+
+type slotUser struct {
+	active  int
+	waiting []chan struct{}
+
+	// served is the sequence number of the last slot the job got.
+	served int
+}
+
+// acquire waits for a slot of the node.
+func (s *nodeSlots) acquire(id, jobID string, ctx context.Context) error {
+	s.mu.Lock()
+	q, ok := s.nodes[id]
+	if !ok {
+		q = &nodeQueue{jobs: make(map[string]*slotUser)}
+		s.nodes[id] = q
+	}
+
+	u, ok := q.jobs[jobID]
+	if !ok {
+		u = &slotUser{}
+		q.jobs[jobID] = u
+	}
+
+	if q.used < s.limit {
+		q.used++
+		s.grant(u)
+		s.mu.Unlock()
+		return nil
+	}
+
+	ch := make(chan struct{})
+	u.waiting = append(u.waiting, ch)
+	s.mu.Unlock()
+
+	select {
+	case <-ch:
+		return nil
+	case <-ctx.Done():
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		select {
+		case <-ch:
+			// The slot was handed over in the meantime.
+			s.releaseLocked(id, jobID)
+		default:
+			for i, c := range u.waiting {
+				if c == ch {
+					u.waiting = append(u.waiting[:i:i], u.waiting[i+1:]...)
+					break
+				}
+			}
+			q.cleanup(jobID)
+		}
+
+		return ctx.Err()
+	}
+}
+
+// release hands the slot of the node to a waiting job or frees it.
+func (s *nodeSlots) release(id, jobID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.releaseLocked(id, jobID)
+}
+
+func (s *nodeSlots) releaseLocked(id, jobID string) {
+	q := s.nodes[id]
+	q.jobs[jobID].active--
+	q.cleanup(jobID)
+
+	// Prefer the job with the fewest slots and then the one that waited
+	// the longest since its last slot.
+	var next *slotUser
+	for _, u := range q.jobs {
+		if len(u.waiting) == 0 {
+			continue
+		}
+
+		if next == nil || u.active < next.active || (u.active == next.active && u.served < next.served) {
+			next = u
+		}
+	}
+
+	if next == nil {
+		q.used--
+		if q.used == 0 {
+			delete(s.nodes, id)
+		}
+		return
+	}
+
+	ch := next.waiting[0]
+	next.waiting = next.waiting[1:]
+	s.grant(next)
+	close(ch)
+}
+
+func (s *nodeSlots) grant(u *slotUser) {
+	s.seq++
+	u.served = s.seq
+	u.active++
+}
+
+// cleanup drops the job once it neither uses nor waits for a slot.
+func (q *nodeQueue) cleanup(jobID string) {
+	if u := q.jobs[jobID]; u.active == 0 && len(u.waiting) == 0 {
+		delete(q.jobs, jobID)
+	}
+}
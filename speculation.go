@@ -0,0 +1,116 @@
+package mapreduce
+
+import (
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// WithSpeculation enables speculative execution. When a file is not
+// calculated within the given duration, a duplicate Network.Execute is
+// launched on a different replica. The first result is used and the other
+// Network.Execute is canceled. A value of 0 (the default) disables
+// speculative execution.
+func WithSpeculation(after time.Duration) MapReduceOption {
+	return func(r *MapReduce) {
+		r.speculateAfter = after
+	}
+}
+
+// SpeculationStats reports how often speculative execution was used.
+type SpeculationStats struct {
+	// Launched is the number of duplicate Network.Executes.
+	Launched int64
+
+	// Won is the number of duplicates that returned the result before the
+	// original Network.Execute.
+	Won int64
+
+	// Lost is the number of duplicates that did not return the result.
+	Lost int64
+}
+
+type speculationStats struct {
+	launched, won, lost int64
+}
+
+// SpeculationStats returns the statistics of every Calculate.
+func (r MapReduce) SpeculationStats() SpeculationStats {
+	return SpeculationStats{
+		Launched: atomic.LoadInt64(&r.speculation.launched),
+		Won:      atomic.LoadInt64(&r.speculation.won),
+		Lost:     atomic.LoadInt64(&r.speculation.lost),
+	}
+}
+
+// attempt is the outcome of a single Network.Execute for a file.
+type attempt struct {
+	fileResult
+	speculative bool
+}
+
+// runFile calculates the file on the given node. It launches a duplicate on
+// another replica if the calculation is slow (see WithSpeculation).
+func (j *job) runFile(fileName, id string, ids []string, ctx context.Context) (map[string][]byte, error) {
+	r := j.r
+	if r.speculateAfter <= 0 || len(ids) < 2 {
+		return j.executeReplicas(fileName, id, ids, false, ctx)
+	}
+
+	// Canceling the context stops the attempt that lost.
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	attempts := make(chan attempt, 2)
+	launch := func(id string, speculative bool) {
+		go func() {
+			result, err := j.executeReplicas(fileName, id, ids, speculative, ctx)
+			attempts <- attempt{
+				fileResult:  fileResult{file: fileName, result: result, err: err},
+				speculative: speculative,
+			}
+		}()
+	}
+	launch(id, false)
+
+	timer := time.NewTimer(r.speculateAfter)
+	defer timer.Stop()
+
+	running := 1
+	var speculated bool
+	for {
+		select {
+		case a := <-attempts:
+			running--
+			if a.err != nil && running > 0 {
+				// Wait for the other attempt.
+				continue
+			}
+
+			// Stop the attempt that lost and wait for it to return.
+			cancel()
+			for ; running > 0; running-- {
+				<-attempts
+			}
+
+			if speculated {
+				if a.speculative && a.err == nil {
+					atomic.AddInt64(&r.speculation.won, 1)
+				} else {
+					atomic.AddInt64(&r.speculation.lost, 1)
+				}
+			}
+
+			return a.result, a.err
+		case <-timer.C:
+			other := r.pickNode(ids, id)
+			j.logger.Info("speculatively start calculation", "file", fileName, "node", other, "slow_node", id)
+			atomic.AddInt64(&r.speculation.launched, 1)
+			j.emit(ProgressEvent{Type: FileRetried, File: fileName, NodeID: other, Speculative: true})
+			speculated = true
+			running++
+			launch(other, true)
+		}
+	}
+}
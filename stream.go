@@ -0,0 +1,352 @@
+package mapreduce
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"sort"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// FollowFileSystem is an optional extension of a FileSystem for files that
+// keep growing. It is required by a Stream.
+type FollowFileSystem interface {
+	FileSystem
+
+	// Follow returns a reader that waits for new records at the end of the
+	// file instead of returning io.EOF. The reader returns io.EOF once the
+	// file will not grow anymore and the error of the context when the
+	// context is done.
+	Follow(file string, ctx context.Context, meta []byte) (reader func() (data []byte, err error), err error)
+}
+
+// TimestampFunc returns the event time of a record.
+type TimestampFunc func(value []byte) (time.Time, error)
+
+// Window is the time range [Start, End) of a window. Start and End are in
+// UTC.
+type Window struct {
+	Start, End time.Time
+}
+
+// WindowResult is the reduced data of a closed window.
+type WindowResult struct {
+	Window Window
+
+	// Result is the reduced data for each key.
+	Result map[string][]byte
+}
+
+// Stream applies an algorithm continuously to files that keep growing. The
+// records are assigned to windows by their event time and each window is
+// reduced once it is closed.
+//
+// A Stream has to be created with NewStream().
+type Stream struct {
+	algFetcher AlgorithmFetcher
+	fs         FollowFileSystem
+	timestamp  TimestampFunc
+
+	size     time.Duration
+	slide    time.Duration
+	lateness time.Duration
+
+	maxReduceIterations int
+	logger              *slog.Logger
+}
+
+// StreamOption is used to configure a new Stream.
+type StreamOption func(*Stream)
+
+// NewStream returns a new Stream with tumbling windows of the given size. It
+// panics if the size is not positive or the slide (see WithSlide) is larger
+// than the size.
+func NewStream(algFetcher AlgorithmFetcher, fs FollowFileSystem, timestamp TimestampFunc, size time.Duration, opts ...StreamOption) *Stream {
+	if size <= 0 {
+		panic(fmt.Sprintf("mapreduce: invalid window size %s", size))
+	}
+
+	s := &Stream{
+		algFetcher: algFetcher,
+		fs:         fs,
+		timestamp:  timestamp,
+		size:       size,
+		slide:      size,
+		logger:     discardLogger(),
+	}
+
+	for _, o := range opts {
+		o(s)
+	}
+
+	if s.slide <= 0 {
+		s.slide = s.size
+	}
+
+	if s.slide > s.size {
+		panic(fmt.Sprintf("mapreduce: slide %s is larger than the window size %s", s.slide, s.size))
+	}
+
+	return s
+}
+
+// WithSlide starts a window every slide instead of every size so the windows
+// overlap (sliding windows). Each record is part of size/slide windows.
+func WithSlide(slide time.Duration) StreamOption {
+	return func(s *Stream) {
+		s.slide = slide
+	}
+}
+
+// WithAllowedLateness delays the closing of windows. The watermark of each
+// file trails its latest event time by the given duration. Records of windows
+// that were already closed are dropped. A value of 0 (the default) expects
+// the records of each file to be in order.
+func WithAllowedLateness(d time.Duration) StreamOption {
+	return func(s *Stream) {
+		s.lateness = d
+	}
+}
+
+// WithStreamMaxReduceIterations limits how often the Reducer is invoked for a
+// single key of a window (see WithMaxReduceIterations).
+func WithStreamMaxReduceIterations(n int) StreamOption {
+	return func(s *Stream) {
+		s.maxReduceIterations = n
+	}
+}
+
+// WithStreamLogger sets the logger for structured logs of the Stream.
+func WithStreamLogger(l *slog.Logger) StreamOption {
+	return func(s *Stream) {
+		s.logger = l
+	}
+}
+
+// streamRecord is a mapped record of a file.
+type streamRecord struct {
+	file string
+	ts   time.Time
+	key  string
+	data []byte
+}
+
+// partialReduce is the number of values of a key after which the values of
+// an open window are reduced.
+const partialReduce = 64
+
+// Run follows the files that FileSystem returns for the given route and
+// applies the algorithm (algName). Each file has a watermark that follows
+// its event times. A window is closed once the watermark of every file that
+// did not end passes its end, so a file without records holds the windows
+// open. The results of closed windows are sent in the order of their start.
+//
+// Run returns when the context is done or an error occurs. It returns nil
+// when every file ended; the open windows are closed first. A failing
+// TimestampFunc or Mapper aborts the Stream. As the Reducer is applied to
+// open windows, it has to accept its own output.
+func (s *Stream) Run(route, algName string, ctx context.Context, meta []byte, results chan<- WindowResult) error {
+	spec, ok := ResolveJobSpec(ctx, meta)
+	if ok {
+		var cancel context.CancelFunc
+		ctx, cancel = withJobSpecDeadline(ctx, spec)
+		defer cancel()
+	}
+
+	if spec.Meta != nil {
+		meta = spec.Meta
+	}
+
+	alg, err := s.algFetcher.Alg(algName, meta)
+	if err != nil {
+		return err
+	}
+
+	if err := checkVersion(algName, spec.AlgVersion, alg); err != nil {
+		return err
+	}
+
+	files, err := s.fs.Files(route, ctx, meta)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	var wg sync.WaitGroup
+	defer func() {
+		cancel()
+		wg.Wait()
+	}()
+
+	logger := s.logger.With("job", spec.ID, "alg", algName, "route", route)
+	records := make(chan streamRecord)
+	done := make(chan fileResult, len(files))
+	watermarks := make(map[string]time.Time, len(files))
+	for fileName := range files {
+		watermarks[fileName] = time.Time{}
+		wg.Add(1)
+		go func(fileName string) {
+			defer wg.Done()
+			done <- fileResult{file: fileName, err: s.follow(fileName, algName, ctx, meta, alg, records)}
+		}(fileName)
+	}
+
+	windows := make(map[Window]map[string][][]byte)
+	var watermark time.Time
+	for len(watermarks) > 0 {
+		select {
+		case r := <-records:
+			added, err := s.add(windows, r, watermark, alg, algName)
+			if err != nil {
+				return err
+			}
+
+			if !added {
+				logger.Debug("dropped late record", "file", r.file, "timestamp", r.ts, "watermark", watermark)
+				continue
+			}
+
+			if w := r.ts.Add(-s.lateness); w.After(watermarks[r.file]) {
+				watermarks[r.file] = w
+			}
+		case res := <-done:
+			if res.err != nil {
+				return res.err
+			}
+			delete(watermarks, res.file)
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+
+		if w := minWatermark(watermarks); w.After(watermark) {
+			watermark = w
+			if err := s.close(windows, watermark, false, alg, algName, ctx, results); err != nil {
+				return err
+			}
+		}
+	}
+
+	return s.close(windows, watermark, true, alg, algName, ctx, results)
+}
+
+// minWatermark returns the earliest watermark of the files.
+func minWatermark(watermarks map[string]time.Time) time.Time {
+	var min time.Time
+	first := true
+	for _, w := range watermarks {
+		if first || w.Before(min) {
+			min, first = w, false
+		}
+	}
+
+	return min
+}
+
+// follow maps the records of the file.
+func (s *Stream) follow(fileName, algName string, ctx context.Context, meta []byte, alg Algorithm, records chan<- streamRecord) error {
+	reader, err := s.fs.Follow(fileName, ctx, meta)
+	if err != nil {
+		return err
+	}
+
+	for {
+		value, err := reader()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		ts, err := s.timestamp(value)
+		if err != nil {
+			return err
+		}
+
+		key, data, err := safeMap(alg, value, algName, fileName)
+		if err != nil {
+			return err
+		}
+
+		if len(key) == 0 {
+			continue
+		}
+
+		select {
+		case records <- streamRecord{file: fileName, ts: ts, key: key, data: data}:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// add assigns the record to each open window that contains its event time.
+// It reports false if every window of the record was already closed.
+func (s *Stream) add(windows map[Window]map[string][][]byte, r streamRecord, watermark time.Time, alg Algorithm, algName string) (bool, error) {
+	var added bool
+	ts := r.ts.UTC()
+	for start := ts.Truncate(s.slide); start.Add(s.size).After(ts); start = start.Add(-s.slide) {
+		w := Window{Start: start, End: start.Add(s.size)}
+		if !w.End.After(watermark) {
+			continue
+		}
+		added = true
+
+		m, ok := windows[w]
+		if !ok {
+			m = make(map[string][][]byte)
+			windows[w] = m
+		}
+
+		m[r.key] = append(m[r.key], r.data)
+		if len(m[r.key]) < partialReduce {
+			continue
+		}
+
+		reduced, _, err := reduceAll(alg, m[r.key], algName, "", r.key, s.maxReduceIterations)
+		if err != nil {
+			return false, err
+		}
+		m[r.key] = [][]byte{reduced}
+	}
+
+	return added, nil
+}
+
+// close reduces and sends the windows that end before the watermark or
+// every window if all is set.
+func (s *Stream) close(windows map[Window]map[string][][]byte, watermark time.Time, all bool, alg Algorithm, algName string, ctx context.Context, results chan<- WindowResult) error {
+	var closed []Window
+	for w := range windows {
+		if all || !w.End.After(watermark) {
+			closed = append(closed, w)
+		}
+	}
+
+	sort.Slice(closed, func(i, j int) bool {
+		return closed[i].Start.Before(closed[j].Start)
+	})
+
+	for _, w := range closed {
+		result := make(map[string][]byte)
+		for key, values := range windows[w] {
+			reduced, _, err := reduceAll(alg, values, algName, "", key, s.maxReduceIterations)
+			if err != nil {
+				return err
+			}
+			result[key] = reduced
+		}
+		delete(windows, w)
+
+		select {
+		case results <- WindowResult{Window: w, Result: result}:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	return nil
+}
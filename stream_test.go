@@ -0,0 +1,226 @@
+package mapreduce_test
+
+import (
+	"context"
+	"io"
+	"math"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/poy/mapreduce"
+	"github.com/poy/onpar"
+	. "github.com/poy/onpar/expect"
+	. "github.com/poy/onpar/matchers"
+)
+
+type TS struct {
+	*testing.T
+	fs      followFileSystem
+	results chan mapreduce.WindowResult
+	errs    chan error
+	run     func(s *mapreduce.Stream)
+}
+
+func TestStream(t *testing.T) {
+	t.Parallel()
+	o := onpar.New()
+	defer o.Run(t)
+
+	o.BeforeEach(func(t *testing.T) TS {
+		fs := followFileSystem{"a": make(chan []byte, 10), "b": make(chan []byte, 10)}
+		results := make(chan mapreduce.WindowResult, 10)
+		errs := make(chan error, 1)
+
+		return TS{
+			T:       t,
+			fs:      fs,
+			results: results,
+			errs:    errs,
+			run: func(s *mapreduce.Stream) {
+				go func() {
+					errs <- s.Run("some-route", "count", context.Background(), nil, results)
+				}()
+			},
+		}
+	})
+
+	newStream := func(t TS, opts ...mapreduce.StreamOption) *mapreduce.Stream {
+		return mapreduce.NewStream(mapreduce.AlgFetcherMap{"count": countAlg()}, t.fs, secondsTimestamp, 10*time.Second, opts...)
+	}
+
+	o.Spec("it reduces tumbling windows once the watermark passes them", func(t TS) {
+		t.run(newStream(t))
+
+		t.fs["a"] <- []byte("1")
+		t.fs["b"] <- []byte("5")
+		Expect(t, t.results).To(Always(HaveLen(0)))
+
+		t.fs["a"] <- []byte("12")
+		Expect(t, t.results).To(Always(HaveLen(0)))
+
+		t.fs["b"] <- []byte("11")
+		var r mapreduce.WindowResult
+		Expect(t, t.results).To(ViaPolling(Chain(Receive(), Fetch(&r))))
+		Expect(t, r.Window.Start).To(Equal(time.Unix(0, 0).UTC()))
+		Expect(t, r.Window.End).To(Equal(time.Unix(10, 0).UTC()))
+		Expect(t, string(r.Result["count"])).To(Equal("2"))
+
+		close(t.fs["a"])
+		close(t.fs["b"])
+		Expect(t, t.results).To(ViaPolling(Chain(Receive(), Fetch(&r))))
+		Expect(t, r.Window.Start).To(Equal(time.Unix(10, 0).UTC()))
+		Expect(t, string(r.Result["count"])).To(Equal("2"))
+		Expect(t, <-t.errs == nil).To(BeTrue())
+	})
+
+	o.Spec("it assigns records to every sliding window", func(t TS) {
+		t.run(newStream(t, mapreduce.WithSlide(5*time.Second)))
+
+		t.fs["a"] <- []byte("7")
+		close(t.fs["a"])
+		close(t.fs["b"])
+		Expect(t, <-t.errs == nil).To(BeTrue())
+
+		Expect(t, t.results).To(HaveLen(2))
+		first, second := <-t.results, <-t.results
+		Expect(t, first.Window.Start).To(Equal(time.Unix(0, 0).UTC()))
+		Expect(t, second.Window.Start).To(Equal(time.Unix(5, 0).UTC()))
+		Expect(t, string(second.Result["count"])).To(Equal("1"))
+	})
+
+	o.Spec("it drops the records of closed windows", func(t TS) {
+		t.run(newStream(t))
+
+		t.fs["a"] <- []byte("1")
+		t.fs["b"] <- []byte("1")
+		t.fs["a"] <- []byte("12")
+		t.fs["b"] <- []byte("12")
+		Expect(t, t.results).To(ViaPolling(Receive()))
+
+		t.fs["a"] <- []byte("3")
+		t.fs["a"] <- []byte("14")
+		close(t.fs["a"])
+		close(t.fs["b"])
+		Expect(t, <-t.errs == nil).To(BeTrue())
+
+		Expect(t, t.results).To(HaveLen(1))
+		Expect(t, string((<-t.results).Result["count"])).To(Equal("3"))
+	})
+
+	o.Spec("it does not drop the records of a file that is behind", func(t TS) {
+		t.run(newStream(t))
+
+		t.fs["a"] <- []byte("1")
+		t.fs["a"] <- []byte("25")
+		t.fs["b"] <- []byte("3")
+		t.fs["b"] <- []byte("4")
+		Expect(t, t.results).To(Always(HaveLen(0)))
+
+		close(t.fs["a"])
+		close(t.fs["b"])
+		Expect(t, <-t.errs == nil).To(BeTrue())
+
+		Expect(t, t.results).To(HaveLen(2))
+		r := <-t.results
+		Expect(t, r.Window.Start).To(Equal(time.Unix(0, 0).UTC()))
+		Expect(t, string(r.Result["count"])).To(Equal("3"))
+		Expect(t, string((<-t.results).Result["count"])).To(Equal("1"))
+	})
+
+	o.Spec("it keeps windows open for late records", func(t TS) {
+		t.run(newStream(t, mapreduce.WithAllowedLateness(5*time.Second)))
+
+		t.fs["a"] <- []byte("12")
+		t.fs["a"] <- []byte("3")
+		t.fs["a"] <- []byte("16")
+		t.fs["b"] <- []byte("16")
+		var r mapreduce.WindowResult
+		Expect(t, t.results).To(ViaPolling(Chain(Receive(), Fetch(&r))))
+		Expect(t, r.Window.Start).To(Equal(time.Unix(0, 0).UTC()))
+		Expect(t, string(r.Result["count"])).To(Equal("1"))
+
+		close(t.fs["a"])
+		close(t.fs["b"])
+		Expect(t, <-t.errs == nil).To(BeTrue())
+	})
+
+	o.Spec("it panics for invalid windows", func(t TS) {
+		panics := func(size time.Duration, opts ...mapreduce.StreamOption) (panicked bool) {
+			defer func() { panicked = recover() != nil }()
+			mapreduce.NewStream(mapreduce.AlgFetcherMap{"count": countAlg()}, t.fs, secondsTimestamp, size, opts...)
+			return false
+		}
+
+		Expect(t, panics(0)).To(BeTrue())
+		Expect(t, panics(-time.Second)).To(BeTrue())
+		Expect(t, panics(time.Second, mapreduce.WithSlide(2*time.Second))).To(BeTrue())
+		Expect(t, panics(time.Second, mapreduce.WithSlide(time.Second))).To(BeFalse())
+	})
+}
+
+// countAlg counts the records.
+func countAlg() mapreduce.Algorithm {
+	return mapreduce.Algorithm{
+		Mapper: mapreduce.MapFunc(func(value []byte) (string, []byte, error) {
+			return "count", []byte("1"), nil
+		}),
+		Reducer: sumReducer{},
+	}
+}
+
+// sumReducer sums integers.
+type sumReducer struct{}
+
+func (sumReducer) Reduce(values [][]byte) ([][]byte, error) {
+	var sum int
+	for _, v := range values {
+		n, _ := strconv.Atoi(string(v))
+		sum += n
+	}
+	return [][]byte{[]byte(strconv.Itoa(sum))}, nil
+}
+
+func (sumReducer) ParseEstimate(reduced []byte) (float64, error) {
+	return strconv.ParseFloat(string(reduced), 64)
+}
+
+func (sumReducer) FormatEstimate(v float64) []byte {
+	return []byte(strconv.Itoa(int(math.Round(v))))
+}
+
+// secondsTimestamp reads the record as seconds since the epoch.
+func secondsTimestamp(value []byte) (time.Time, error) {
+	n, err := strconv.Atoi(string(value))
+	return time.Unix(int64(n), 0), err
+}
+
+// followFileSystem serves the records of each channel until it is closed.
+type followFileSystem map[string]chan []byte
+
+func (fs followFileSystem) Files(route string, ctx context.Context, meta []byte) (map[string][]string, error) {
+	files := make(map[string][]string)
+	for name := range fs {
+		files[name] = []string{"node"}
+	}
+	return files, nil
+}
+
+func (fs followFileSystem) Reader(file string, ctx context.Context, meta []byte) (func() ([]byte, error), error) {
+	return func() ([]byte, error) { return nil, io.EOF }, nil
+}
+
+func (fs followFileSystem) Follow(file string, ctx context.Context, meta []byte) (func() ([]byte, error), error) {
+	ch := fs[file]
+	return func() ([]byte, error) {
+		select {
+		case value, ok := <-ch:
+			if !ok {
+				return nil, io.EOF
+			}
+			return value, nil
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}, nil
+}
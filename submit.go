@@ -0,0 +1,176 @@
+package mapreduce
+
+import (
+	"errors"
+	"sync"
+
+	"golang.org/x/net/context"
+)
+
+// ErrJobRunning is returned by JobHandle.Result when the job has not
+// finished yet.
+var ErrJobRunning = errors.New("job is still running")
+
+// JobHandle is used to follow a job that was submitted via Submit.
+type JobHandle interface {
+	// ID returns the ID of the job.
+	ID() string
+
+	// Done is closed when the job finished.
+	Done() <-chan struct{}
+
+	// Wait blocks until the job finished and returns its result. It
+	// returns the error of the context if the context is done first. The
+	// job keeps running.
+	Wait(ctx context.Context) (result map[string][]byte, err error)
+
+	// Result returns the result of the finished job. It returns
+	// ErrJobRunning if the job has not finished yet.
+	Result() (result map[string][]byte, err error)
+
+	// Status returns the status of the job.
+	Status() JobStatus
+
+	// Cancel cancels the job. The job's result is ErrJobCanceled.
+	Cancel() error
+}
+
+// Submit starts the job in the background and returns immediately. It is
+// otherwise equivalent to Calculate. The job is not bound to a context; it
+// is stopped via JobHandle.Cancel, Cancel or its deadline (see JobSpec and
+// WithJobTimeout). Concurrent jobs share the nodes fairly when WithNodeSlots
+// is used.
+//
+// It returns ErrDuplicateJob if a job with the same ID is already running.
+func (r MapReduce) Submit(route, algName string, meta []byte) (JobHandle, error) {
+	spec, _ := ResolveJobSpec(context.Background(), meta)
+	if spec.ID == "" {
+		spec.ID = newJobID()
+	}
+	if spec.Route == "" {
+		spec.Route = route
+	}
+
+	// The job is registered before it starts so a duplicate ID is rejected
+	// here and the job can be canceled right away.
+	ctx, cancel := context.WithCancel(WithJobSpec(context.Background(), spec))
+	pending := newJob(r, algName, spec, cancel)
+	if err := r.jobs.add(pending, nil); err != nil {
+		cancel()
+		return nil, err
+	}
+
+	h := &jobHandle{
+		r:      r,
+		id:     spec.ID,
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+
+	go func() {
+		defer cancel()
+		result, err := r.Calculate(route, algName, context.WithValue(ctx, pendingJobKey{}, pending), meta)
+
+		// The pending job is still registered if Calculate failed early.
+		r.jobs.done(pending, JobSummary{Err: err})
+		h.finish(result, err)
+	}()
+
+	return h, nil
+}
+
+// pendingJobKey is the context key of the job that Submit registered.
+type pendingJobKey struct{}
+
+// pendingJob returns the job that Submit registered for the Calculate.
+func pendingJob(ctx context.Context) *job {
+	j, _ := ctx.Value(pendingJobKey{}).(*job)
+	return j
+}
+
+type jobHandle struct {
+	r      MapReduce
+	id     string
+	cancel context.CancelFunc
+	done   chan struct{}
+
+	mu       sync.Mutex
+	canceled bool
+	result   map[string][]byte
+	err      error
+}
+
+func (h *jobHandle) ID() string {
+	return h.id
+}
+
+func (h *jobHandle) Done() <-chan struct{} {
+	return h.done
+}
+
+func (h *jobHandle) Wait(ctx context.Context) (map[string][]byte, error) {
+	select {
+	case <-h.done:
+		return h.Result()
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (h *jobHandle) Result() (map[string][]byte, error) {
+	select {
+	case <-h.done:
+	default:
+		return nil, ErrJobRunning
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.result, h.err
+}
+
+func (h *jobHandle) Status() JobStatus {
+	select {
+	case <-h.done:
+	default:
+		return JobRunning
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	switch {
+	case h.err == ErrJobCanceled:
+		return JobCanceled
+	case h.err != nil:
+		return JobFailed
+	default:
+		return JobSucceeded
+	}
+}
+
+// Cancel cancels the context of the job and notifies the nodes (see
+// MapReduce.Cancel).
+func (h *jobHandle) Cancel() error {
+	h.mu.Lock()
+	h.canceled = true
+	h.mu.Unlock()
+
+	if err := h.r.Cancel(h.id); err != nil && err != ErrUnknownJob {
+		return err
+	}
+	h.cancel()
+
+	return nil
+}
+
+func (h *jobHandle) finish(result map[string][]byte, err error) {
+	h.mu.Lock()
+	if err != nil && h.canceled {
+		err = ErrJobCanceled
+	}
+	h.result, h.err = result, err
+	h.mu.Unlock()
+
+	close(h.done)
+}
@@ -0,0 +1,210 @@
+package mapreduce
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// TenantQuota limits the executions of a tenant on an Executor. A zero
+// value does not limit anything.
+type TenantQuota struct {
+	// MaxConcurrentFiles limits the number of concurrent executions.
+	// Further executions are rejected with a *QuotaError.
+	MaxConcurrentFiles int
+
+	// RecordsPerSecond throttles the reading of records across every
+	// execution of the tenant.
+	RecordsPerSecond float64
+
+	// MaxBytesRead limits the bytes that are read until the usage is reset
+	// (see ResetTenantUsage). Executions that exceed it fail with a
+	// *QuotaError.
+	MaxBytesRead int64
+}
+
+// QuotaError is returned when an execution exceeds a TenantQuota.
+type QuotaError struct {
+	Tenant string
+
+	// Quota is the name of the exceeded quota (e.g., MaxBytesRead).
+	Quota string
+	Limit int64
+}
+
+// Error implements error.
+func (e *QuotaError) Error() string {
+	return fmt.Sprintf("tenant %q exceeded quota %s (limit %d)", e.Tenant, e.Quota, e.Limit)
+}
+
+// TenantUsage is the usage of a tenant on an Executor.
+type TenantUsage struct {
+	// Executions counts the executions that were started.
+	Executions int64
+
+	// Running is the number of running executions.
+	Running int
+
+	Records   int64
+	BytesRead int64
+
+	// Rejected counts the executions that failed due to a quota.
+	Rejected int64
+
+	// Throttled is the time executions waited due to RecordsPerSecond.
+	Throttled time.Duration
+}
+
+// WithTenantQuota sets the quota of the tenant (see JobSpec.Tenant).
+func WithTenantQuota(tenant string, q TenantQuota) ExecutorOption {
+	return func(e *Executor) {
+		e.tenants.quotas[tenant] = q
+	}
+}
+
+// WithDefaultTenantQuota sets the quota of tenants that do not have their own
+// quota. This includes jobs without a tenant.
+func WithDefaultTenantQuota(q TenantQuota) ExecutorOption {
+	return func(e *Executor) {
+		e.tenants.defaultQuota = q
+	}
+}
+
+// TenantUsage returns the usage of every tenant since the last reset.
+func (e *Executor) TenantUsage() map[string]TenantUsage {
+	return e.tenants.usage(false)
+}
+
+// ResetTenantUsage returns the usage of every tenant and resets it. Running
+// executions are kept.
+func (e *Executor) ResetTenantUsage() map[string]TenantUsage {
+	return e.tenants.usage(true)
+}
+
+type tenants struct {
+	quotas       map[string]TenantQuota
+	defaultQuota TenantQuota
+
+	mu    sync.Mutex
+	state map[string]*tenantState
+}
+
+func newTenants() *tenants {
+	return &tenants{
+		quotas: make(map[string]TenantQuota),
+		state:  make(map[string]*tenantState),
+	}
+}
+
+// tenantState tracks the usage of a tenant. It is guarded by the mutex of
+// the tenants.
+type tenantState struct {
+	g      *tenants
+	name   string
+	quota  TenantQuota
+	usage  TenantUsage
+	nextAt time.Time
+}
+
+// start registers an execution of the tenant. It returns a *QuotaError if
+// the tenant runs too many executions.
+func (g *tenants) start(tenant string) (*tenantState, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	s, ok := g.state[tenant]
+	if !ok {
+		q, ok := g.quotas[tenant]
+		if !ok {
+			q = g.defaultQuota
+		}
+
+		s = &tenantState{g: g, name: tenant, quota: q}
+		g.state[tenant] = s
+	}
+
+	if s.quota.MaxConcurrentFiles > 0 && s.usage.Running >= s.quota.MaxConcurrentFiles {
+		s.usage.Rejected++
+		return nil, &QuotaError{
+			Tenant: tenant,
+			Quota:  "MaxConcurrentFiles",
+			Limit:  int64(s.quota.MaxConcurrentFiles),
+		}
+	}
+
+	s.usage.Executions++
+	s.usage.Running++
+
+	return s, nil
+}
+
+func (s *tenantState) done() {
+	s.g.mu.Lock()
+	defer s.g.mu.Unlock()
+	s.usage.Running--
+}
+
+// record accounts for a record that was read. It waits if the tenant reads
+// too many records per second and returns a *QuotaError if the tenant read
+// too many bytes.
+func (s *tenantState) record(size int, ctx context.Context) error {
+	s.g.mu.Lock()
+	s.usage.Records++
+	s.usage.BytesRead += int64(size)
+
+	if s.quota.MaxBytesRead > 0 && s.usage.BytesRead > s.quota.MaxBytesRead {
+		s.usage.Rejected++
+		s.g.mu.Unlock()
+		return &QuotaError{
+			Tenant: s.name,
+			Quota:  "MaxBytesRead",
+			Limit:  s.quota.MaxBytesRead,
+		}
+	}
+
+	if s.quota.RecordsPerSecond <= 0 {
+		s.g.mu.Unlock()
+		return nil
+	}
+
+	// Each record reserves the next interval of the tenant.
+	now := time.Now()
+	if s.nextAt.Before(now) {
+		s.nextAt = now
+	}
+	wait := s.nextAt.Sub(now)
+	s.nextAt = s.nextAt.Add(time.Duration(float64(time.Second) / s.quota.RecordsPerSecond))
+	s.usage.Throttled += wait
+	s.g.mu.Unlock()
+
+	if wait <= 0 {
+		return nil
+	}
+
+	t := time.NewTimer(wait)
+	defer t.Stop()
+
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (g *tenants) usage(reset bool) map[string]TenantUsage {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	m := make(map[string]TenantUsage, len(g.state))
+	for name, s := range g.state {
+		m[name] = s.usage
+		if reset {
+			s.usage = TenantUsage{Running: s.usage.Running}
+		}
+	}
+
+	return m
+}
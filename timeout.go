@@ -0,0 +1,80 @@
+package mapreduce
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// WithJobTimeout limits the duration of a whole Calculate. A value of 0 (the
+// default) only applies the deadline of the context.
+func WithJobTimeout(d time.Duration) MapReduceOption {
+	return func(r *MapReduce) {
+		r.jobTimeout = d
+	}
+}
+
+// WithExecuteTimeout limits the duration of each Network.Execute. A value of
+// 0 (the default) only applies the deadline of the job.
+func WithExecuteTimeout(d time.Duration) MapReduceOption {
+	return func(r *MapReduce) {
+		r.executeTimeout = d
+	}
+}
+
+// TimeoutError is returned when a deadline was exceeded before every file was
+// calculated.
+type TimeoutError struct {
+	// Outstanding maps each file that was not calculated yet to the node
+	// that was calculating it.
+	Outstanding map[string]string
+}
+
+// Error implements error.
+func (e *TimeoutError) Error() string {
+	files := make([]string, 0, len(e.Outstanding))
+	for file, id := range e.Outstanding {
+		files = append(files, fmt.Sprintf("%s (node %s)", file, id))
+	}
+	sort.Strings(files)
+
+	return fmt.Sprintf("timed out waiting for %d files: %s", len(files), strings.Join(files, ", "))
+}
+
+// Unwrap returns context.DeadlineExceeded.
+func (e *TimeoutError) Unwrap() error {
+	return context.DeadlineExceeded
+}
+
+// newTimeoutError returns a *TimeoutError with a copy of the outstanding
+// files.
+func newTimeoutError(outstanding map[string]string) *TimeoutError {
+	o := make(map[string]string, len(outstanding))
+	for file, id := range outstanding {
+		o[file] = id
+	}
+
+	return &TimeoutError{Outstanding: o}
+}
+
+// withTimeout returns the earlier of the deadline and now plus the timeout.
+// A zero deadline and a timeout of 0 are ignored.
+func withTimeout(deadline time.Time, timeout time.Duration) time.Time {
+	if timeout <= 0 {
+		return deadline
+	}
+
+	return earliest(deadline, time.Now().Add(timeout))
+}
+
+// earliest returns the earlier deadline. A zero deadline is ignored.
+func earliest(a, b time.Time) time.Time {
+	if a.IsZero() || (!b.IsZero() && b.Before(a)) {
+		return b
+	}
+
+	return a
+}
@@ -0,0 +1,165 @@
+package mapreduce
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"golang.org/x/net/context"
+)
+
+// SpanContext identifies a span of a trace.
+type SpanContext struct {
+	TraceID [16]byte
+	SpanID  [8]byte
+}
+
+// IsValid reports if the trace and span ID are set.
+func (c SpanContext) IsValid() bool {
+	return c.TraceID != [16]byte{} && c.SpanID != [8]byte{}
+}
+
+// Traceparent returns the SpanContext in the W3C traceparent format (e.g.,
+// 00-<trace ID>-<span ID>-01).
+func (c SpanContext) Traceparent() string {
+	return fmt.Sprintf("00-%s-%s-01", hex.EncodeToString(c.TraceID[:]), hex.EncodeToString(c.SpanID[:]))
+}
+
+// ParseTraceparent decodes a SpanContext in the W3C traceparent format.
+func ParseTraceparent(s string) (SpanContext, error) {
+	var c SpanContext
+	parts := strings.Split(s, "-")
+	if len(parts) != 4 || parts[0] != "00" || len(parts[1]) != 32 || len(parts[2]) != 16 {
+		return c, fmt.Errorf("invalid traceparent: %q", s)
+	}
+
+	if _, err := hex.Decode(c.TraceID[:], []byte(parts[1])); err != nil {
+		return c, fmt.Errorf("invalid traceparent: %q", s)
+	}
+
+	if _, err := hex.Decode(c.SpanID[:], []byte(parts[2])); err != nil {
+		return c, fmt.Errorf("invalid traceparent: %q", s)
+	}
+
+	if !c.IsValid() {
+		return c, fmt.Errorf("invalid traceparent: %q", s)
+	}
+
+	return c, nil
+}
+
+// Span is a timed operation of a trace.
+type Span interface {
+	// SpanContext returns the IDs of the span. Spans that are not
+	// recorded return an invalid SpanContext.
+	SpanContext() SpanContext
+
+	// SetAttributes attaches the attributes to the span.
+	SetAttributes(attrs ...Label)
+
+	// End finishes the span. A non-nil error marks the span as failed.
+	End(err error)
+}
+
+// Tracer starts spans. It has to be safe for concurrent use. The tracing
+// subpackage provides an in-memory implementation.
+type Tracer interface {
+	// Start starts a span with the given name. The parent of the span is
+	// returned by ParentFromContext.
+	Start(ctx context.Context, name string) Span
+}
+
+// The names of the spans.
+const (
+	// CalculateSpan covers a MapReduce.Calculate.
+	CalculateSpan = "mapreduce.Calculate"
+
+	// FilesSpan covers the FileSystem.Files of a Calculate.
+	FilesSpan = "mapreduce.Files"
+
+	// NetworkExecuteSpan covers a Network.Execute.
+	NetworkExecuteSpan = "mapreduce.Network.Execute"
+
+	// ExecuteSpan covers an Executor.Execute.
+	ExecuteSpan = "mapreduce.Executor.Execute"
+
+	// MapSpan covers reading and mapping the records of a file.
+	MapSpan = "mapreduce.Map"
+
+	// ReduceSpan covers reducing every key of a file (Executor) or of
+	// every file (MapReduce).
+	ReduceSpan = "mapreduce.Reduce"
+)
+
+// WithTracer sets the Tracer of the MapReduce. The SpanContext of each
+// NetworkExecuteSpan is stored in the JobSpec's Traceparent so the spans of
+// the node can be its children.
+func WithTracer(t Tracer) MapReduceOption {
+	return func(r *MapReduce) {
+		r.tracer = t
+	}
+}
+
+// WithExecutorTracer sets the Tracer of the Executor.
+func WithExecutorTracer(t Tracer) ExecutorOption {
+	return func(e *Executor) {
+		e.tracer = t
+	}
+}
+
+type spanKey struct{}
+
+// ContextWithSpan returns a copy of the context that holds the span.
+func ContextWithSpan(ctx context.Context, span Span) context.Context {
+	return context.WithValue(ctx, spanKey{}, span)
+}
+
+// SpanFromContext returns the span that was stored in the context via
+// ContextWithSpan.
+func SpanFromContext(ctx context.Context) (Span, bool) {
+	s, ok := ctx.Value(spanKey{}).(Span)
+	return s, ok
+}
+
+// ParentFromContext returns the SpanContext of the span in the context. If
+// the context does not hold a valid span, it falls back to the Traceparent
+// of the JobSpec in the context. This allows a node to continue the trace of
+// the coordinator.
+func ParentFromContext(ctx context.Context) (SpanContext, bool) {
+	if s, ok := SpanFromContext(ctx); ok && s.SpanContext().IsValid() {
+		return s.SpanContext(), true
+	}
+
+	spec, ok := JobSpecFromContext(ctx)
+	if !ok || spec.Traceparent == "" {
+		return SpanContext{}, false
+	}
+
+	c, err := ParseTraceparent(spec.Traceparent)
+	if err != nil {
+		return SpanContext{}, false
+	}
+
+	return c, true
+}
+
+// startSpan starts a span and stores it in the returned context.
+func startSpan(t Tracer, ctx context.Context, name string, attrs ...Label) (context.Context, Span) {
+	span := t.Start(ctx, name)
+	if len(attrs) > 0 {
+		span.SetAttributes(attrs...)
+	}
+
+	return ContextWithSpan(ctx, span), span
+}
+
+// nopTracer starts spans that are not recorded.
+type nopTracer struct{}
+
+func (nopTracer) Start(ctx context.Context, name string) Span { return nopSpan{} }
+
+type nopSpan struct{}
+
+func (nopSpan) SpanContext() SpanContext     { return SpanContext{} }
+func (nopSpan) SetAttributes(attrs ...Label) {}
+func (nopSpan) End(err error)                {}
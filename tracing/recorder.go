@@ -0,0 +1,138 @@
+// tracing provides an in-memory implementation of mapreduce.Tracer. It is
+// meant for tests and debugging.
+package tracing
+
+import (
+	"crypto/rand"
+	"sync"
+	"time"
+
+	"github.com/poy/mapreduce"
+	"golang.org/x/net/context"
+)
+
+// SpanData is a finished span.
+type SpanData struct {
+	Name string
+
+	mapreduce.SpanContext
+
+	// Parent is invalid for the root span of a trace.
+	Parent mapreduce.SpanContext
+
+	Start, End time.Time
+	Attributes []mapreduce.Label
+	Err        error
+}
+
+// Attribute returns the value of the attribute with the given name.
+func (d SpanData) Attribute(name string) (string, bool) {
+	for _, a := range d.Attributes {
+		if a.Name == name {
+			return a.Value, true
+		}
+	}
+
+	return "", false
+}
+
+// Recorder implements mapreduce.Tracer. It keeps every finished span in
+// memory.
+//
+// A Recorder has to be created with NewRecorder().
+type Recorder struct {
+	mu    sync.Mutex
+	spans []SpanData
+}
+
+// NewRecorder returns a new Recorder.
+func NewRecorder() *Recorder {
+	return &Recorder{}
+}
+
+// Start implements mapreduce.Tracer. A span without a parent starts a new
+// trace.
+func (r *Recorder) Start(ctx context.Context, name string) mapreduce.Span {
+	s := &span{
+		r: r,
+		data: SpanData{
+			Name:  name,
+			Start: time.Now(),
+		},
+	}
+
+	if parent, ok := mapreduce.ParentFromContext(ctx); ok {
+		s.data.Parent = parent
+		s.data.TraceID = parent.TraceID
+	} else {
+		rand.Read(s.data.TraceID[:])
+	}
+	rand.Read(s.data.SpanID[:])
+
+	return s
+}
+
+// Spans returns the finished spans in the order they were finished.
+func (r *Recorder) Spans() []SpanData {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return append([]SpanData(nil), r.spans...)
+}
+
+// Find returns the finished spans with the given name.
+func (r *Recorder) Find(name string) []SpanData {
+	var spans []SpanData
+	for _, s := range r.Spans() {
+		if s.Name == name {
+			spans = append(spans, s)
+		}
+	}
+
+	return spans
+}
+
+// Reset drops every finished span.
+func (r *Recorder) Reset() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.spans = nil
+}
+
+type span struct {
+	r *Recorder
+
+	mu    sync.Mutex
+	data  SpanData
+	ended bool
+}
+
+func (s *span) SpanContext() mapreduce.SpanContext {
+	return s.data.SpanContext
+}
+
+func (s *span) SetAttributes(attrs ...mapreduce.Label) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.data.Attributes = append(s.data.Attributes, attrs...)
+}
+
+// End records the span. Only the first call has an effect.
+func (s *span) End(err error) {
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.data.End = time.Now()
+	s.data.Err = err
+	data := s.data
+	s.mu.Unlock()
+
+	s.r.mu.Lock()
+	defer s.r.mu.Unlock()
+	s.r.spans = append(s.r.spans, data)
+}
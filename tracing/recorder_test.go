@@ -0,0 +1,107 @@
+package tracing_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/poy/mapreduce"
+	"github.com/poy/mapreduce/tracing"
+	"github.com/poy/onpar"
+	. "github.com/poy/onpar/expect"
+	. "github.com/poy/onpar/matchers"
+)
+
+type TR struct {
+	*testing.T
+	r *tracing.Recorder
+}
+
+func TestRecorder(t *testing.T) {
+	t.Parallel()
+	o := onpar.New()
+	defer o.Run(t)
+
+	o.BeforeEach(func(t *testing.T) TR {
+		return TR{
+			T: t,
+			r: tracing.NewRecorder(),
+		}
+	})
+
+	o.Spec("it records finished spans", func(t TR) {
+		s := t.r.Start(context.Background(), "some-span")
+		s.SetAttributes(mapreduce.Label{Name: "a", Value: "b"})
+		Expect(t, t.r.Spans()).To(HaveLen(0))
+
+		s.End(errors.New("some-error"))
+		s.End(nil)
+
+		spans := t.r.Spans()
+		Expect(t, spans).To(HaveLen(1))
+		Expect(t, spans[0].Name).To(Equal("some-span"))
+		Expect(t, spans[0].IsValid()).To(BeTrue())
+		Expect(t, spans[0].Parent.IsValid()).To(BeFalse())
+		Expect(t, spans[0].Err).To(Equal(errors.New("some-error")))
+
+		v, ok := spans[0].Attribute("a")
+		Expect(t, ok).To(BeTrue())
+		Expect(t, v).To(Equal("b"))
+	})
+
+	o.Spec("it uses the span in the context as parent", func(t TR) {
+		parent := t.r.Start(context.Background(), "parent")
+		child := t.r.Start(mapreduce.ContextWithSpan(context.Background(), parent), "child")
+		child.End(nil)
+
+		spans := t.r.Find("child")
+		Expect(t, spans).To(HaveLen(1))
+		Expect(t, spans[0].TraceID).To(Equal(parent.SpanContext().TraceID))
+		Expect(t, spans[0].Parent).To(Equal(parent.SpanContext()))
+	})
+
+	o.Spec("it uses the Traceparent of the JobSpec as parent", func(t TR) {
+		parent := t.r.Start(context.Background(), "parent")
+		ctx := mapreduce.WithJobSpec(context.Background(), mapreduce.JobSpec{
+			Traceparent: parent.SpanContext().Traceparent(),
+		})
+		t.r.Start(ctx, "child").End(nil)
+
+		spans := t.r.Find("child")
+		Expect(t, spans).To(HaveLen(1))
+		Expect(t, spans[0].Parent).To(Equal(parent.SpanContext()))
+	})
+
+	o.Spec("it starts a new trace for each root span", func(t TR) {
+		a := t.r.Start(context.Background(), "a")
+		b := t.r.Start(context.Background(), "b")
+		Expect(t, a.SpanContext().TraceID == b.SpanContext().TraceID).To(BeFalse())
+	})
+}
+
+func TestTraceparent(t *testing.T) {
+	t.Parallel()
+	o := onpar.New()
+	defer o.Run(t)
+
+	o.Spec("it survives a round trip", func(t *testing.T) {
+		c := tracing.NewRecorder().Start(context.Background(), "a").SpanContext()
+
+		parsed, err := mapreduce.ParseTraceparent(c.Traceparent())
+		Expect(t, err == nil).To(BeTrue())
+		Expect(t, parsed).To(Equal(c))
+	})
+
+	o.Spec("it rejects invalid values", func(t *testing.T) {
+		for _, s := range []string{
+			"",
+			"00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331",
+			"01-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01",
+			"00-0af7651916cd43dd8448eb211c80319x-b7ad6b7169203331-01",
+			"00-00000000000000000000000000000000-b7ad6b7169203331-01",
+		} {
+			_, err := mapreduce.ParseTraceparent(s)
+			Expect(t, err == nil).To(BeFalse())
+		}
+	})
+}
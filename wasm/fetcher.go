@@ -0,0 +1,435 @@
+// wasm is used to run algorithms that are compiled to WebAssembly. Modules
+// are identified by their content hash (see Hash), which is used as the
+// algorithm name and version. Compiled modules are cached by the Fetcher.
+//
+// A module has to export its memory as "memory" and the following
+// functions:
+//
+//	alloc(size i32) i32
+//	    Returns the offset where an input of the given size is written.
+//	map(ptr i32, len i32) i64
+//	reduce(ptr i32, len i32) i64
+//
+// map and reduce return the offset of their output in the upper 32 bits and
+// its length in the lower 32 bits. Every length is a little endian uint32.
+//
+// The input of map is the value. Its output is the length of the key, the
+// key and then the output. A key of length 0 filters out the value.
+//
+// The input of reduce is a list of values, where each value is prefixed by
+// its length. Its output is encoded the same way.
+package wasm
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/poy/mapreduce"
+	"github.com/poy/mapreduce/internal/lru"
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/api"
+)
+
+const hashPrefix = "sha256:"
+
+// Hash returns the content hash of a module.
+func Hash(module []byte) string {
+	sum := sha256.Sum256(module)
+	return hashPrefix + hex.EncodeToString(sum[:])
+}
+
+// Source is used to fetch modules by their content hash.
+type Source interface {
+	// Module returns the module with the given content hash.
+	Module(hash string) (module []byte, err error)
+}
+
+// MemorySource implements Source.
+type MemorySource map[string][]byte
+
+// Add stores the module and returns its content hash.
+func (s MemorySource) Add(module []byte) (hash string) {
+	hash = Hash(module)
+	s[hash] = module
+	return hash
+}
+
+// Module implements Source.
+func (s MemorySource) Module(hash string) ([]byte, error) {
+	m, ok := s[hash]
+	if !ok {
+		return nil, fmt.Errorf("unknown module: %s", hash)
+	}
+
+	return m, nil
+}
+
+// DirSource implements Source. It reads modules from the directory. Each
+// module is stored as <hex encoded hash>.wasm.
+type DirSource string
+
+// Module implements Source.
+func (s DirSource) Module(hash string) ([]byte, error) {
+	if !strings.HasPrefix(hash, hashPrefix) {
+		return nil, fmt.Errorf("invalid module hash: %s", hash)
+	}
+
+	name := strings.TrimPrefix(hash, hashPrefix)
+	if _, err := hex.DecodeString(name); err != nil {
+		return nil, fmt.Errorf("invalid module hash: %s", hash)
+	}
+
+	return ioutil.ReadFile(filepath.Join(string(s), name+".wasm"))
+}
+
+// Fetcher implements mapreduce.AlgorithmFetcher. The algorithm name is the
+// content hash of the module.
+//
+// A Fetcher has to be created with NewFetcher().
+type Fetcher struct {
+	src        Source
+	timeout    time.Duration
+	maxPages   uint32
+	poolSize   int
+	maxModules int
+	runtime    wazero.Runtime
+
+	mu      sync.Mutex
+	modules *lru.Cache
+}
+
+// FetcherOption is used to configure a new Fetcher.
+type FetcherOption func(*Fetcher)
+
+// WithTimeout sets the maximum duration of a single map or reduce call. It
+// defaults to 1 second.
+func WithTimeout(d time.Duration) FetcherOption {
+	return func(f *Fetcher) {
+		f.timeout = d
+	}
+}
+
+// WithMaxMemoryPages sets the maximum number of 64KiB memory pages for
+// each instance of a module. It defaults to 256 (16MiB).
+func WithMaxMemoryPages(pages uint32) FetcherOption {
+	return func(f *Fetcher) {
+		f.maxPages = pages
+	}
+}
+
+// WithPoolSize sets the number of idle instances that are kept per module.
+// It defaults to 8.
+func WithPoolSize(size int) FetcherOption {
+	return func(f *Fetcher) {
+		f.poolSize = size
+	}
+}
+
+// WithMaxModules sets the number of compiled modules that are cached. The
+// least recently used module is closed once its running calls returned. It
+// defaults to 64.
+func WithMaxModules(n int) FetcherOption {
+	return func(f *Fetcher) {
+		f.maxModules = n
+	}
+}
+
+// NewFetcher returns a new Fetcher. It has to be closed to release the
+// runtime.
+func NewFetcher(src Source, opts ...FetcherOption) *Fetcher {
+	f := &Fetcher{
+		src:        src,
+		timeout:    time.Second,
+		maxPages:   256,
+		poolSize:   8,
+		maxModules: 64,
+	}
+
+	for _, o := range opts {
+		o(f)
+	}
+	f.modules = lru.New(f.maxModules)
+
+	f.runtime = wazero.NewRuntimeWithConfig(context.Background(), wazero.NewRuntimeConfig().
+		WithCloseOnContextDone(true).
+		WithMemoryLimitPages(f.maxPages),
+	)
+
+	return f
+}
+
+// Close releases the runtime and every module.
+func (f *Fetcher) Close() error {
+	return f.runtime.Close(context.Background())
+}
+
+// Alg implements mapreduce.AlgorithmFetcher.
+func (f *Fetcher) Alg(name string, meta []byte) (mapreduce.Algorithm, error) {
+	c, err := f.acquire(name)
+	if err != nil {
+		return mapreduce.Algorithm{}, err
+	}
+	f.release(c)
+
+	m := &module{f: f, hash: name}
+	return mapreduce.Algorithm{
+		Mapper:  mapreduce.MapFunc(m.Map),
+		Reducer: mapreduce.ReduceFunc(m.Reduce),
+		Version: name,
+	}, nil
+}
+
+// acquire returns the compiled module. It is compiled if it is not cached.
+// The compiled module is not closed before it is released.
+func (f *Fetcher) acquire(hash string) (*compiled, error) {
+	f.mu.Lock()
+	if v, ok := f.modules.Get(hash); ok {
+		c := v.(*compiled)
+		c.refs++
+		f.mu.Unlock()
+		return c, nil
+	}
+	f.mu.Unlock()
+
+	// The module is compiled without the lock so it does not block the
+	// calls of other modules. A module might be compiled twice.
+	c, err := f.compile(hash)
+	if err != nil {
+		return nil, err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if v, ok := f.modules.Get(hash); ok {
+		c.close()
+		c = v.(*compiled)
+		c.refs++
+		return c, nil
+	}
+
+	c.refs++
+	for _, v := range f.modules.Add(hash, c) {
+		e := v.(*compiled)
+		e.evicted = true
+		if e.refs == 0 {
+			e.close()
+		}
+	}
+
+	return c, nil
+}
+
+// release closes the compiled module if it was evicted and this was its
+// last call.
+func (f *Fetcher) release(c *compiled) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	c.refs--
+	if c.evicted && c.refs == 0 {
+		c.close()
+	}
+}
+
+func (f *Fetcher) compile(hash string) (*compiled, error) {
+	bin, err := f.src.Module(hash)
+	if err != nil {
+		return nil, err
+	}
+
+	if Hash(bin) != hash {
+		return nil, fmt.Errorf("module %s does not match its hash", hash)
+	}
+
+	cm, err := f.runtime.CompileModule(context.Background(), bin)
+	if err != nil {
+		return nil, fmt.Errorf("module %s: %s", hash, err)
+	}
+
+	exports := cm.ExportedFunctions()
+	for _, name := range []string{"alloc", "map", "reduce"} {
+		if _, ok := exports[name]; !ok {
+			cm.Close(context.Background())
+			return nil, fmt.Errorf("module %s does not export %s", hash, name)
+		}
+	}
+
+	if _, ok := cm.ExportedMemories()["memory"]; !ok {
+		cm.Close(context.Background())
+		return nil, fmt.Errorf("module %s does not export memory", hash)
+	}
+
+	return &compiled{
+		module: cm,
+		pool:   make(chan api.Module, f.poolSize),
+	}, nil
+}
+
+// compiled is a cached module. Instances are not safe for concurrent use,
+// therefore each call takes an instance from the pool.
+type compiled struct {
+	module wazero.CompiledModule
+	pool   chan api.Module
+
+	// refs is the number of running calls. An evicted module is closed
+	// once there are none. Both are guarded by the mutex of the Fetcher.
+	refs    int
+	evicted bool
+}
+
+func (c *compiled) close() {
+	for {
+		select {
+		case inst := <-c.pool:
+			inst.Close(context.Background())
+		default:
+			c.module.Close(context.Background())
+			return
+		}
+	}
+}
+
+// module runs the functions of a module. It acquires the compiled module
+// for each call, so it keeps working after the module was evicted.
+type module struct {
+	f    *Fetcher
+	hash string
+}
+
+// Map implements mapreduce.Mapper.
+func (m *module) Map(value []byte) (key string, output []byte, err error) {
+	out, err := m.call("map", value)
+	if err != nil {
+		return "", nil, err
+	}
+
+	if len(out) < 4 {
+		return "", nil, m.malformed("map")
+	}
+
+	n := binary.LittleEndian.Uint32(out)
+	if uint64(n) > uint64(len(out)-4) {
+		return "", nil, m.malformed("map")
+	}
+
+	if n == 0 {
+		return "", nil, nil
+	}
+
+	return string(out[4 : 4+n]), out[4+n:], nil
+}
+
+// Reduce implements mapreduce.Reducer.
+func (m *module) Reduce(value [][]byte) (reduced [][]byte, err error) {
+	var in []byte
+	for _, v := range value {
+		in = binary.LittleEndian.AppendUint32(in, uint32(len(v)))
+		in = append(in, v...)
+	}
+
+	out, err := m.call("reduce", in)
+	if err != nil {
+		return nil, err
+	}
+
+	for len(out) > 0 {
+		if len(out) < 4 {
+			return nil, m.malformed("reduce")
+		}
+
+		n := binary.LittleEndian.Uint32(out)
+		out = out[4:]
+		if uint64(n) > uint64(len(out)) {
+			return nil, m.malformed("reduce")
+		}
+
+		reduced = append(reduced, out[:n])
+		out = out[n:]
+	}
+
+	return reduced, nil
+}
+
+// call writes the input to the memory of an instance, invokes the function
+// and returns a copy of its output.
+func (m *module) call(fn string, input []byte) ([]byte, error) {
+	c, err := m.f.acquire(m.hash)
+	if err != nil {
+		return nil, err
+	}
+	defer m.f.release(c)
+
+	ctx, cancel := context.WithTimeout(context.Background(), m.f.timeout)
+	defer cancel()
+
+	inst, err := m.instance(ctx, c)
+	if err != nil {
+		return nil, m.err(fn, err)
+	}
+
+	out, err := m.invoke(ctx, inst, fn, input)
+	if err != nil {
+		// The instance might be closed or in an undefined state.
+		inst.Close(context.Background())
+		return nil, m.err(fn, err)
+	}
+
+	select {
+	case c.pool <- inst:
+	default:
+		inst.Close(context.Background())
+	}
+
+	return out, nil
+}
+
+func (m *module) invoke(ctx context.Context, inst api.Module, fn string, input []byte) ([]byte, error) {
+	res, err := inst.ExportedFunction("alloc").Call(ctx, uint64(len(input)))
+	if err != nil {
+		return nil, err
+	}
+
+	ptr := uint32(res[0])
+	if !inst.Memory().Write(ptr, input) {
+		return nil, fmt.Errorf("alloc returned an out of range offset")
+	}
+
+	res, err = inst.ExportedFunction(fn).Call(ctx, uint64(ptr), uint64(len(input)))
+	if err != nil {
+		return nil, err
+	}
+
+	out, ok := inst.Memory().Read(uint32(res[0]>>32), uint32(res[0]))
+	if !ok {
+		return nil, fmt.Errorf("returned an out of range output")
+	}
+
+	return append([]byte(nil), out...), nil
+}
+
+func (m *module) instance(ctx context.Context, c *compiled) (api.Module, error) {
+	select {
+	case inst := <-c.pool:
+		return inst, nil
+	default:
+		// Anonymous modules can be instantiated several times.
+		return m.f.runtime.InstantiateModule(ctx, c.module, wazero.NewModuleConfig().WithName(""))
+	}
+}
+
+func (m *module) err(fn string, err error) error {
+	return fmt.Errorf("module %s: %s: %s", m.hash, fn, err)
+}
+
+func (m *module) malformed(fn string) error {
+	return fmt.Errorf("module %s: %s returned a malformed output", m.hash, fn)
+}
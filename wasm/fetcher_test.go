@@ -0,0 +1,161 @@
+package wasm_test
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/poy/mapreduce/wasm"
+	"github.com/poy/onpar"
+	. "github.com/poy/onpar/expect"
+	. "github.com/poy/onpar/matchers"
+)
+
+// The fixtures in testdata are built from the .wat files next to them.
+
+type TF struct {
+	*testing.T
+	f         *wasm.Fetcher
+	src       wasm.MemorySource
+	countHash string
+	loopHash  string
+}
+
+func TestFetcher(t *testing.T) {
+	t.Parallel()
+	o := onpar.New()
+	defer o.Run(t)
+
+	o.BeforeEach(func(t *testing.T) TF {
+		src := make(wasm.MemorySource)
+		return TF{
+			T:         t,
+			src:       src,
+			countHash: src.Add(readFixture(t, "count.wasm")),
+			loopHash:  src.Add(readFixture(t, "loop.wasm")),
+			f:         wasm.NewFetcher(src, wasm.WithTimeout(100*time.Millisecond)),
+		}
+	})
+
+	o.AfterEach(func(t TF) {
+		t.f.Close()
+	})
+
+	o.Spec("it maps the values", func(t TF) {
+		alg, err := t.f.Alg(t.countHash, nil)
+		Expect(t, err == nil).To(BeTrue())
+
+		key, output, err := alg.Map([]byte("abc"))
+		Expect(t, err == nil).To(BeTrue())
+		Expect(t, key).To(Equal("a"))
+		Expect(t, output).To(Equal(uint32Bytes(1)))
+	})
+
+	o.Spec("it filters values", func(t TF) {
+		alg, _ := t.f.Alg(t.countHash, nil)
+
+		key, _, err := alg.Map(nil)
+		Expect(t, err == nil).To(BeTrue())
+		Expect(t, key).To(HaveLen(0))
+	})
+
+	o.Spec("it reduces the values", func(t TF) {
+		alg, _ := t.f.Alg(t.countHash, nil)
+
+		reduced, err := alg.Reduce([][]byte{uint32Bytes(1), uint32Bytes(2), uint32Bytes(3)})
+		Expect(t, err == nil).To(BeTrue())
+		Expect(t, reduced).To(Equal([][]byte{uint32Bytes(6)}))
+	})
+
+	o.Spec("it reuses instances", func(t TF) {
+		alg, _ := t.f.Alg(t.countHash, nil)
+
+		for i := 0; i < 100; i++ {
+			key, _, err := alg.Map([]byte{byte(i)})
+			Expect(t, err == nil).To(BeTrue())
+			Expect(t, key).To(Equal(string([]byte{byte(i)})))
+		}
+	})
+
+	o.Spec("it keeps evicted modules working", func(t TF) {
+		f := wasm.NewFetcher(t.src, wasm.WithMaxModules(1))
+		defer f.Close()
+
+		count, err := f.Alg(t.countHash, nil)
+		Expect(t, err == nil).To(BeTrue())
+		_, err = f.Alg(t.loopHash, nil)
+		Expect(t, err == nil).To(BeTrue())
+
+		key, _, err := count.Map([]byte("abc"))
+		Expect(t, err == nil).To(BeTrue())
+		Expect(t, key).To(Equal("a"))
+	})
+
+	o.Spec("it uses the hash as version", func(t TF) {
+		alg, _ := t.f.Alg(t.countHash, nil)
+		Expect(t, alg.Version).To(Equal(t.countHash))
+	})
+
+	o.Spec("it enforces the timeout", func(t TF) {
+		alg, err := t.f.Alg(t.loopHash, nil)
+		Expect(t, err == nil).To(BeTrue())
+
+		_, _, err = alg.Map([]byte("a"))
+		Expect(t, err == nil).To(BeFalse())
+
+		_, err = alg.Reduce([][]byte{[]byte("a")})
+		Expect(t, err == nil).To(BeFalse())
+	})
+
+	o.Spec("it returns an error for an unknown module", func(t TF) {
+		_, err := t.f.Alg("sha256:unknown", nil)
+		Expect(t, err == nil).To(BeFalse())
+	})
+
+	o.Spec("it returns an error when the module does not match the hash", func(t TF) {
+		t.src[t.countHash] = readFixture(t.T, "loop.wasm")
+		f := wasm.NewFetcher(t.src)
+		defer f.Close()
+
+		_, err := f.Alg(t.countHash, nil)
+		Expect(t, err == nil).To(BeFalse())
+	})
+
+	o.Spec("it reads modules from a directory", func(t TF) {
+		dir, err := ioutil.TempDir("", "wasm")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+
+		name := strings.TrimPrefix(t.countHash, "sha256:") + ".wasm"
+		if err := ioutil.WriteFile(filepath.Join(dir, name), readFixture(t.T, "count.wasm"), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		f := wasm.NewFetcher(wasm.DirSource(dir))
+		defer f.Close()
+
+		_, err = f.Alg(t.countHash, nil)
+		Expect(t, err == nil).To(BeTrue())
+	})
+}
+
+func readFixture(t *testing.T, name string) []byte {
+	data, err := ioutil.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return data
+}
+
+func uint32Bytes(i uint32) []byte {
+	b := make([]byte, 4)
+	binary.LittleEndian.PutUint32(b, i)
+	return b
+}
@@ -0,0 +1,58 @@
+;; count maps every value to its first byte and counts the values per key.
+;; The outputs are little endian uint32s.
+(module
+  (memory (export "memory") 1)
+
+  ;; alloc returns the offset for an input of the given size. Every call
+  ;; reuses the same region, the outputs are written right after the input.
+  (func (export "alloc") (param $size i32) (result i32)
+    (local $pages i32)
+    (local.set $pages
+      (i32.add
+        (i32.shr_u
+          (i32.add (i32.const 1088) (i32.shl (local.get $size) (i32.const 1)))
+          (i32.const 16))
+        (i32.const 1)))
+    (if (i32.gt_u (local.get $pages) (memory.size))
+      (then
+        (drop (memory.grow (i32.sub (local.get $pages) (memory.size))))))
+    (i32.const 1024))
+
+  ;; map returns (value[0], 1). Empty values are filtered out.
+  (func (export "map") (param $ptr i32) (param $len i32) (result i64)
+    (local $out i32)
+    (local.set $out (i32.add (local.get $ptr) (local.get $len)))
+    (if (i32.eqz (local.get $len))
+      (then
+        (i32.store (local.get $out) (i32.const 0))
+        (return
+          (i64.or
+            (i64.shl (i64.extend_i32_u (local.get $out)) (i64.const 32))
+            (i64.const 4)))))
+    (i32.store (local.get $out) (i32.const 1))
+    (i32.store8 offset=4 (local.get $out) (i32.load8_u (local.get $ptr)))
+    (i32.store offset=5 (local.get $out) (i32.const 1))
+    (i64.or
+      (i64.shl (i64.extend_i32_u (local.get $out)) (i64.const 32))
+      (i64.const 9)))
+
+  ;; reduce sums the values.
+  (func (export "reduce") (param $ptr i32) (param $len i32) (result i64)
+    (local $end i32)
+    (local $sum i32)
+    (local.set $end (i32.add (local.get $ptr) (local.get $len)))
+    (block $done
+      (loop $next
+        (br_if $done (i32.ge_u (local.get $ptr) (local.get $end)))
+        (local.set $sum
+          (i32.add (local.get $sum) (i32.load offset=4 (local.get $ptr))))
+        (local.set $ptr
+          (i32.add
+            (local.get $ptr)
+            (i32.add (i32.const 4) (i32.load (local.get $ptr)))))
+        (br $next)))
+    (i32.store (local.get $end) (i32.const 4))
+    (i32.store offset=4 (local.get $end) (local.get $sum))
+    (i64.or
+      (i64.shl (i64.extend_i32_u (local.get $end)) (i64.const 32))
+      (i64.const 8))))
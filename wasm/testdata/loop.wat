@@ -0,0 +1,16 @@
+;; loop never returns from map or reduce. It is used to test the time limit.
+(module
+  (memory (export "memory") 1)
+
+  (func (export "alloc") (param $size i32) (result i32)
+    (i32.const 1024))
+
+  (func (export "map") (param $ptr i32) (param $len i32) (result i64)
+    (loop $forever
+      (br $forever))
+    (i64.const 0))
+
+  (func (export "reduce") (param $ptr i32) (param $len i32) (result i64)
+    (loop $forever
+      (br $forever))
+    (i64.const 0)))